@@ -25,6 +25,14 @@ func Init() (err error) {
 	createTables := `CREATE TABLE IF NOT EXISTS users (id integer not null primary key, type text , mxid text, twitch_name text, twitch_token text, twitch_token_id text);
 					CREATE TABLE IF NOT EXISTS tokens (id integer not null primary key, access_token text, token_type text, refresh_token text, expiry text);
 					CREATE TABLE IF NOT EXISTS rooms (id integer not null primary key, room_alias text, room_id text, twitch_channel text);
+					CREATE TABLE IF NOT EXISTS messages (id integer not null primary key, twitch_id text, event_id text, room_id text, sender text, timestamp integer);
+					CREATE INDEX IF NOT EXISTS messages_twitch_id ON messages (twitch_id);
+					CREATE INDEX IF NOT EXISTS messages_event_id ON messages (event_id);
+					CREATE INDEX IF NOT EXISTS messages_timestamp ON messages (timestamp);
+					CREATE TABLE IF NOT EXISTS emotes (id integer not null primary key, emote_id text unique, mxc text);
+					CREATE TABLE IF NOT EXISTS whisper_rooms (id integer not null primary key, room_id text unique, mxid text, twitch_name text, unique (mxid, twitch_name));
+					CREATE TABLE IF NOT EXISTS media (id integer not null primary key, token text unique, mxc text, expires integer);
+					CREATE TABLE IF NOT EXISTS edits (id integer not null primary key, event_id text unique, text text);
 					`
 	_, execErr := db.Exec(createTables)
 	if execErr != nil {
@@ -42,3 +50,11 @@ func Open() *sql.DB {
 	}
 	return nil
 }
+
+// Close closes the in Init() created db variable
+func Close() error {
+	if db == nil {
+		return nil
+	}
+	return db.Close()
+}
@@ -0,0 +1,28 @@
+package implementation
+
+import (
+	"database/sql"
+	dbHelper "github.com/Nordgedanken/matrix-twitch-bridge/asLogic/db/helper"
+)
+
+// SaveEdit saves the text the last bridged edit of an event set on Twitch
+func (d *DB) SaveEdit(eventID, text string) error {
+	if d.db == nil {
+		d.db = dbHelper.Open()
+	}
+	_, err := d.db.Exec("INSERT OR REPLACE INTO edits (event_id, text) VALUES (?, ?)", eventID, text)
+	return err
+}
+
+// GetEdit returns the text of the last bridged edit of an event or an empty string if it wasn't edited yet
+func (d *DB) GetEdit(eventID string) (string, error) {
+	if d.db == nil {
+		d.db = dbHelper.Open()
+	}
+	var text string
+	err := d.db.QueryRow("SELECT text FROM edits WHERE event_id = ?", eventID).Scan(&text)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return text, err
+}
@@ -0,0 +1,28 @@
+package implementation
+
+import (
+	"database/sql"
+	dbHelper "github.com/Nordgedanken/matrix-twitch-bridge/asLogic/db/helper"
+)
+
+// SaveEmote saves the mxc URI a Twitch emote got uploaded to
+func (d *DB) SaveEmote(emoteID, mxc string) error {
+	if d.db == nil {
+		d.db = dbHelper.Open()
+	}
+	_, err := d.db.Exec("INSERT OR REPLACE INTO emotes (emote_id, mxc) VALUES (?, ?)", emoteID, mxc)
+	return err
+}
+
+// GetEmote returns the mxc URI of a Twitch emote or an empty string if it wasn't uploaded yet
+func (d *DB) GetEmote(emoteID string) (string, error) {
+	if d.db == nil {
+		d.db = dbHelper.Open()
+	}
+	var mxc string
+	err := d.db.QueryRow("SELECT mxc FROM emotes WHERE emote_id = ?", emoteID).Scan(&mxc)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return mxc, err
+}
@@ -0,0 +1,45 @@
+package implementation
+
+import (
+	"database/sql"
+	dbHelper "github.com/Nordgedanken/matrix-twitch-bridge/asLogic/db/helper"
+	"time"
+)
+
+// SaveMedia saves the mxc URI a media proxy token points to. A zero expires never expires
+func (d *DB) SaveMedia(token, mxc string, expires time.Time) error {
+	if d.db == nil {
+		d.db = dbHelper.Open()
+	}
+	var expiresMS int64
+	if !expires.IsZero() {
+		expiresMS = expires.UnixNano() / int64(time.Millisecond)
+	}
+	_, err := d.db.Exec("INSERT INTO media (token, mxc, expires) VALUES (?, ?, ?)", token, mxc, expiresMS)
+	return err
+}
+
+// GetMedia returns the mxc URI of a media proxy token or an empty string if it is unknown or expired
+func (d *DB) GetMedia(token string) (string, error) {
+	if d.db == nil {
+		d.db = dbHelper.Open()
+	}
+	var mxc string
+	err := d.db.QueryRow("SELECT mxc FROM media WHERE token = ? AND (expires = 0 OR expires > ?)", token, time.Now().UnixNano()/int64(time.Millisecond)).Scan(&mxc)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return mxc, err
+}
+
+// PruneMedia deletes all media proxy tokens which expired before now and returns how many got deleted
+func (d *DB) PruneMedia(now time.Time) (int64, error) {
+	if d.db == nil {
+		d.db = dbHelper.Open()
+	}
+	res, err := d.db.Exec("DELETE FROM media WHERE expires != 0 AND expires <= ?", now.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,120 @@
+package implementation
+
+import (
+	"database/sql"
+	dbHelper "github.com/Nordgedanken/matrix-twitch-bridge/asLogic/db/helper"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/room"
+	"time"
+)
+
+// SaveMessage saves the mapping between a Twitch message and a Matrix event to the Database
+func (d *DB) SaveMessage(message *room.Message) error {
+	if d.db == nil {
+		d.db = dbHelper.Open()
+	}
+	_, err := d.db.Exec("INSERT INTO messages (twitch_id, event_id, room_id, sender, timestamp) VALUES (?, ?, ?, ?, ?)", message.TwitchID, message.EventID, message.RoomID, message.Sender, message.Timestamp.UnixNano()/int64(time.Millisecond))
+	return err
+}
+
+// GetMessageByTwitchID returns the mapping for a Twitch message id or nil if it is unknown
+func (d *DB) GetMessageByTwitchID(twitchID string) (*room.Message, error) {
+	if d.db == nil {
+		d.db = dbHelper.Open()
+	}
+	row := d.db.QueryRow("SELECT twitch_id, event_id, room_id, sender, timestamp FROM messages WHERE twitch_id = ?", twitchID)
+	message, err := scanMessage(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return message, err
+}
+
+// GetMessageByEventID returns the mapping for a Matrix event id or nil if it is unknown.
+// If the event got split into multiple Twitch messages the first one is returned.
+func (d *DB) GetMessageByEventID(eventID string) (*room.Message, error) {
+	if d.db == nil {
+		d.db = dbHelper.Open()
+	}
+	row := d.db.QueryRow("SELECT twitch_id, event_id, room_id, sender, timestamp FROM messages WHERE event_id = ? ORDER BY id LIMIT 1", eventID)
+	message, err := scanMessage(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return message, err
+}
+
+// GetMessagesByEventID returns all mappings for a Matrix event id
+func (d *DB) GetMessagesByEventID(eventID string) ([]*room.Message, error) {
+	if d.db == nil {
+		d.db = dbHelper.Open()
+	}
+	rows, err := d.db.Query("SELECT twitch_id, event_id, room_id, sender, timestamp FROM messages WHERE event_id = ? ORDER BY id", eventID)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// GetMessagesBySender returns all mappings of events the sender sent in the room since the given time
+func (d *DB) GetMessagesBySender(roomID, sender string, since time.Time) ([]*room.Message, error) {
+	if d.db == nil {
+		d.db = dbHelper.Open()
+	}
+	rows, err := d.db.Query("SELECT twitch_id, event_id, room_id, sender, timestamp FROM messages WHERE room_id = ? AND sender = ? AND timestamp >= ?", roomID, sender, since.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+func scanMessages(rows *sql.Rows) ([]*room.Message, error) {
+	defer rows.Close()
+
+	var messages []*room.Message
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	// get any error encountered during iteration
+	err := rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// PruneMessages deletes all mappings older than the given time and returns how many got deleted
+func (d *DB) PruneMessages(before time.Time) (int64, error) {
+	if d.db == nil {
+		d.db = dbHelper.Open()
+	}
+	res, err := d.db.Exec("DELETE FROM messages WHERE timestamp < ?", before.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return 0, err
+	}
+	// Edits can only be bridged while the edited message is known
+	_, err = d.db.Exec("DELETE FROM edits WHERE event_id NOT IN (SELECT event_id FROM messages)")
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMessage(row scanner) (*room.Message, error) {
+	var timestamp int64
+	message := &room.Message{}
+	err := row.Scan(&message.TwitchID, &message.EventID, &message.RoomID, &message.Sender, &timestamp)
+	if err != nil {
+		return nil, err
+	}
+	message.Timestamp = time.Unix(0, timestamp*int64(time.Millisecond))
+	return message, nil
+}
@@ -2,10 +2,9 @@ package implementation
 
 import (
 	"database/sql"
+	"fmt"
 	dbHelper "github.com/Nordgedanken/matrix-twitch-bridge/asLogic/db/helper"
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/matrix_helper"
-	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/queryHandler"
-	wsImpl "github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/websocket/implementation"
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/user"
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
 	"github.com/matrix-org/gomatrix"
@@ -81,6 +80,29 @@ func (d *DB) SaveUser(userA interface{}) error {
 	return err
 }
 
+// UpdateRealUserToken overwrites the stored Twitch token of an already saved Real User, e.g. after it got refreshed
+func (d *DB) UpdateRealUserToken(ruser *user.RealUser) error {
+	if d.db == nil {
+		d.db = dbHelper.Open()
+	}
+	expiry, err := ruser.TwitchTokenStruct.Expiry.MarshalText()
+	if err != nil {
+		return err
+	}
+	res, err := d.db.Exec("UPDATE tokens SET access_token = ?, token_type = ?, refresh_token = ?, expiry = ? WHERE id = (SELECT twitch_token_id FROM users WHERE type = 'REAL' AND mxid = ?)", ruser.TwitchTokenStruct.AccessToken, ruser.TwitchTokenStruct.Type(), ruser.TwitchTokenStruct.RefreshToken, string(expiry[:]), ruser.Mxid)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return fmt.Errorf("no Twitch token saved for %s", ruser.Mxid)
+	}
+	return nil
+}
+
 type userTransportStruct struct {
 	ASUsers   []*user.ASUser
 	RealUsers []*user.RealUser
@@ -125,39 +147,29 @@ func (d *DB) getUsers() (users *userTransportStruct, err error) {
 				var refreshToken string
 				var expiry sql.NullString
 				expiryTime := time.Time{}
-				d.db.QueryRow("SELECT access_token, token_type, refresh_token, expiry FROM tokens WHERE id = "+twitchTokenID.String, &accessToken, &tokenType, &refreshToken, &expiry)
-
-				if expiry.Valid {
-					err = expiryTime.UnmarshalText([]byte(expiry.String))
-					if err != nil {
-						return nil, err
-					}
+				err = d.db.QueryRow("SELECT access_token, token_type, refresh_token, expiry FROM tokens WHERE id = ?", twitchTokenID.String).Scan(&accessToken, &tokenType, &refreshToken, &expiry)
+				if err != nil && err != sql.ErrNoRows {
+					return nil, err
 				}
 
-				TwitchToken = &oauth2.Token{
-					AccessToken:  accessToken,
-					TokenType:    tokenType,
-					RefreshToken: refreshToken,
-				}
-				util.AppService.Log.Debugf("TwitchToken: %+v", TwitchToken)
-				if expiry.Valid {
-					TwitchToken.Expiry = expiryTime
-				}
-			}
-
-			wsHolder := &wsImpl.WebsocketHolder{
-				Done: make(chan struct{}),
+				// Users which didn't finish the login yet have no token
+				if err == nil {
+					if expiry.Valid {
+						err = expiryTime.UnmarshalText([]byte(expiry.String))
+						if err != nil {
+							return nil, err
+						}
+					}
 
-				TwitchRooms: queryHandler.QueryHandler().TwitchRooms,
-				TwitchUsers: queryHandler.QueryHandler().TwitchUsers,
-				RealUsers:   queryHandler.QueryHandler().RealUsers,
-				Users:       queryHandler.QueryHandler().Users,
-			}
-			if TwitchToken != nil {
-				util.AppService.Log.Debugln("tName used for ws nick: ", twitchName)
-				err = wsHolder.Connect(TwitchToken.AccessToken, twitchName)
-				if err != nil {
-					return nil, err
+					TwitchToken = &oauth2.Token{
+						AccessToken:  accessToken,
+						TokenType:    tokenType,
+						RefreshToken: refreshToken,
+					}
+					util.AppService.Log.Debugf("TwitchToken: %+v", TwitchToken)
+					if expiry.Valid {
+						TwitchToken.Expiry = expiryTime
+					}
 				}
 			}
 
@@ -165,7 +177,6 @@ func (d *DB) getUsers() (users *userTransportStruct, err error) {
 				Mxid:              mxid,
 				TwitchTokenStruct: TwitchToken,
 				TwitchName:        twitchName,
-				TwitchWS:          wsHolder,
 			}
 
 			transportStruct.RealUsers = append(transportStruct.RealUsers, RealUser)
@@ -0,0 +1,51 @@
+package implementation
+
+import (
+	"database/sql"
+	dbHelper "github.com/Nordgedanken/matrix-twitch-bridge/asLogic/db/helper"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/room"
+)
+
+// SaveWhisperRoom saves a DM used for whispers. An existing DM between the same users gets replaced
+func (d *DB) SaveWhisperRoom(whisperRoom *room.WhisperRoom) error {
+	if d.db == nil {
+		d.db = dbHelper.Open()
+	}
+	_, err := d.db.Exec("INSERT OR REPLACE INTO whisper_rooms (room_id, mxid, twitch_name) VALUES (?, ?, ?)", whisperRoom.ID, whisperRoom.Mxid, whisperRoom.TwitchName)
+	return err
+}
+
+// GetWhisperRoom returns the DM of the Real User with the Twitch user or nil if there is none
+func (d *DB) GetWhisperRoom(mxid, twitchName string) (*room.WhisperRoom, error) {
+	if d.db == nil {
+		d.db = dbHelper.Open()
+	}
+	row := d.db.QueryRow("SELECT room_id, mxid, twitch_name FROM whisper_rooms WHERE mxid = ? AND twitch_name = ?", mxid, twitchName)
+	whisperRoom, err := scanWhisperRoom(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return whisperRoom, err
+}
+
+// GetWhisperRoomByID returns the DM with the given room ID or nil if the room isn't used for whispers
+func (d *DB) GetWhisperRoomByID(roomID string) (*room.WhisperRoom, error) {
+	if d.db == nil {
+		d.db = dbHelper.Open()
+	}
+	row := d.db.QueryRow("SELECT room_id, mxid, twitch_name FROM whisper_rooms WHERE room_id = ?", roomID)
+	whisperRoom, err := scanWhisperRoom(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return whisperRoom, err
+}
+
+func scanWhisperRoom(row scanner) (*room.WhisperRoom, error) {
+	whisperRoom := &room.WhisperRoom{}
+	err := row.Scan(&whisperRoom.ID, &whisperRoom.Mxid, &whisperRoom.TwitchName)
+	if err != nil {
+		return nil, err
+	}
+	return whisperRoom, nil
+}
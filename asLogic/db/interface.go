@@ -3,6 +3,7 @@ package db
 import (
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/room"
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/user"
+	"time"
 )
 
 type Handler interface {
@@ -11,8 +12,30 @@ type Handler interface {
 	GetTwitchRooms() (rooms map[string]string, err error)
 
 	SaveUser(userA interface{}) error
+	UpdateRealUserToken(ruser *user.RealUser) error
 	GetASUsers() (map[string]*user.ASUser, error)
 	GetTwitchUsers() (map[string]*user.ASUser, error)
 	GetRealUsers() (map[string]*user.RealUser, error)
 	GetBotUser() (*user.BotUser, error)
+
+	SaveMessage(message *room.Message) error
+	GetMessageByTwitchID(twitchID string) (*room.Message, error)
+	GetMessageByEventID(eventID string) (*room.Message, error)
+	GetMessagesByEventID(eventID string) ([]*room.Message, error)
+	GetMessagesBySender(roomID, sender string, since time.Time) ([]*room.Message, error)
+	PruneMessages(before time.Time) (int64, error)
+
+	SaveEdit(eventID, text string) error
+	GetEdit(eventID string) (string, error)
+
+	SaveEmote(emoteID, mxc string) error
+	GetEmote(emoteID string) (string, error)
+
+	SaveMedia(token, mxc string, expires time.Time) error
+	GetMedia(token string) (string, error)
+	PruneMedia(now time.Time) (int64, error)
+
+	SaveWhisperRoom(whisperRoom *room.WhisperRoom) error
+	GetWhisperRoom(mxid, twitchName string) (*room.WhisperRoom, error)
+	GetWhisperRoomByID(roomID string) (*room.WhisperRoom, error)
 }
@@ -0,0 +1,82 @@
+package asLogic
+
+import (
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"maunium.net/go/mautrix/event"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// isEdit checks if the message replaces an earlier message
+func isEdit(content *event.MessageEventContent) bool {
+	return content.RelatesTo != nil && content.RelatesTo.Type == event.RelReplace
+}
+
+// editMessage returns the correction to send to Twitch for an edit and the text the message has after it.
+// The correction is based on the text of the last bridged edit so repeated edits only correct what changed since.
+// It returns an empty correction if the edit is outside util.EditWindow, the edited message never reached Twitch
+// or the text didn't change.
+func editMessage(e *event.Event, content *event.MessageEventContent) (correction, text string, err error) {
+	originalID := content.RelatesTo.GetReplaceID()
+	bridged, err := util.DB.GetMessageByEventID(originalID.String())
+	if err != nil || bridged == nil {
+		return "", "", err
+	}
+
+	original := &event.Event{}
+	err = util.BotUser.MXClient.MakeRequest(http.MethodGet, util.BotUser.MXClient.BuildURL("rooms", e.RoomID.String(), "event", originalID.String()), nil, original)
+	if err != nil {
+		return "", "", err
+	}
+	if util.EditWindow > 0 && time.Duration(e.Timestamp-original.Timestamp)*time.Millisecond > util.EditWindow {
+		util.AppService.Log.Debugln("Dropping edit outside of the edit window")
+		return "", "", nil
+	}
+
+	oldText, err := util.DB.GetEdit(originalID.String())
+	if err != nil {
+		return "", "", err
+	}
+	if oldText == "" {
+		err = original.Content.ParseRaw(event.EventMessage)
+		if err != nil {
+			return "", "", err
+		}
+		oldText = formatForTwitch(original.Content.AsMessage())
+	}
+
+	newContent := content.NewContent
+	if newContent == nil {
+		// Clients without m.new_content only have the "* " fallback
+		newContent = &event.MessageEventContent{Body: strings.TrimPrefix(content.Body, "* ")}
+	}
+	newText := formatForTwitch(newContent)
+	if strings.TrimSpace(oldText) == strings.TrimSpace(newText) {
+		return "", "", nil
+	}
+
+	if util.EditFormat == "diff" {
+		if changed := changedWords(oldText, newText); changed != "" {
+			return "*" + changed, newText, nil
+		}
+	}
+	return "* " + newText, newText, nil
+}
+
+// changedWords returns the words of newText which replace words of oldText.
+// It is empty if words only got removed.
+func changedWords(oldText, newText string) string {
+	oldWords := strings.Fields(oldText)
+	newWords := strings.Fields(newText)
+
+	start := 0
+	for start < len(oldWords) && start < len(newWords) && oldWords[start] == newWords[start] {
+		start++
+	}
+	end := 0
+	for end < len(oldWords)-start && end < len(newWords)-start && oldWords[len(oldWords)-1-end] == newWords[len(newWords)-1-end] {
+		end++
+	}
+	return strings.Join(newWords[start:len(newWords)-end], " ")
+}
@@ -0,0 +1,26 @@
+package asLogic
+
+import "testing"
+
+func TestChangedWords(t *testing.T) {
+	tests := []struct {
+		oldText string
+		newText string
+		want    string
+	}{
+		{"hello wrold", "hello world", "world"},
+		{"the quick fox jumps", "the quick brown fox jumps", "brown"},
+		{"the qiuck borwn fox", "the quick brown fox", "quick brown"},
+		{"same text", "same text", ""},
+		{"hello", "hello again", "again"},
+		{"a b c", "x y z", "x y z"},
+		{"a a", "a a a", "a"},
+	}
+
+	for _, tt := range tests {
+		got := changedWords(tt.oldText, tt.newText)
+		if got != tt.want {
+			t.Errorf("changedWords(%q, %q) = %q, want %q", tt.oldText, tt.newText, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package asLogic
+
+import (
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/queryHandler"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/user"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"strings"
+)
+
+// keepText drops the formatting as Twitch only knows plain text
+func keepText(text string, _ format.Context) string {
+	return text
+}
+
+var twitchParser = &format.HTMLParser{
+	PillConverter:           pillToMention,
+	TabsToSpaces:            4,
+	Newline:                 "\n",
+	HorizontalLine:          "\n---\n",
+	BoldConverter:           keepText,
+	ItalicConverter:         keepText,
+	StrikethroughConverter:  keepText,
+	UnderlineConverter:      keepText,
+	MonospaceConverter:      keepText,
+	MonospaceBlockConverter: func(code, _ string, _ format.Context) string { return code },
+}
+
+// formatForTwitch converts a Matrix message to the plain text sent to Twitch.
+// Reply fallbacks get removed and pills of bridged users become Twitch mentions.
+func formatForTwitch(content *event.MessageEventContent) string {
+	content.RemoveReplyFallback()
+	if content.Format != event.FormatHTML || content.FormattedBody == "" {
+		return content.Body
+	}
+	return strings.TrimSpace(twitchParser.Parse(content.FormattedBody, format.Context{}))
+}
+
+// pillToMention converts a pill to "@login" for Twitch and Real Users or to the display name for everyone else
+func pillToMention(mxid, _ string, _ format.Context) string {
+	if !strings.HasPrefix(mxid, "@") {
+		// Room and event links have no meaning on Twitch
+		return mxid
+	}
+
+	qHandler := queryHandler.QueryHandler()
+	if asUser := user.GetASUser(qHandler.Users, mxid); asUser != nil && asUser.TwitchName != "" {
+		return "@" + asUser.TwitchName
+	}
+	if realUser := user.GetRealUser(qHandler.RealUsers, mxid); realUser != nil && realUser.TwitchName != "" {
+		return "@" + realUser.TwitchName
+	}
+
+	resp, err := util.BotUser.MXClient.GetDisplayName(mxid)
+	if err != nil || resp.DisplayName == "" {
+		return mxid
+	}
+	return resp.DisplayName
+}
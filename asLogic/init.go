@@ -1,10 +1,15 @@
 package asLogic
 
 import (
+	"context"
 	"fmt"
 	dbImpl "github.com/Nordgedanken/matrix-twitch-bridge/asLogic/db/implementation"
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/queryHandler"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/room"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/api"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/irc"
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/login"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/websocket"
 	wsImpl "github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/websocket/implementation"
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/user"
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
@@ -14,8 +19,10 @@ import (
 	"maunium.net/go/maulogger/v2"
 	"maunium.net/go/mautrix/appservice"
 	"maunium.net/go/mautrix/event"
+	"net"
 	"net/http"
 	"os"
+	"time"
 )
 
 // Init starts the interactive AppService generator and exits
@@ -25,7 +32,7 @@ func Init() {
 	boldGreen.Println("Please restart the Twitch-Appservice with \"--client_id\"-flag applied")
 }
 
-func prepareRun() error {
+func prepareRun(ctx context.Context) error {
 	var err error
 
 	util.AppService, err = appservice.Load(util.CfgFile)
@@ -43,6 +50,7 @@ func prepareRun() error {
 
 	util.AppService.Log.Debugln("Creating queryHandler.")
 	qHandler := queryHandler.QueryHandler()
+	qHandler.Context = ctx
 
 	util.AppService.Log.Debugln("Loading Twitch Rooms from DB.")
 	qHandler.TwitchRooms, err = util.DB.GetTwitchRooms()
@@ -91,15 +99,23 @@ func prepareRun() error {
 	util.AppService.Log.Infoln("Starting public server...")
 	r := mux.NewRouter()
 	r.HandleFunc("/callback", login.Callback).Methods(http.MethodGet)
-
+	r.HandleFunc("/media/{token}", MediaProxy).Methods(http.MethodGet)
+
+	publicServer = &http.Server{
+		Addr:    util.Publicaddress,
+		Handler: r,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
 	go func() {
 		var err error
 		if len(util.TLSCert) == 0 || len(util.TLSKey) == 0 {
 			err = fmt.Errorf("You need to have a SSL Cert!")
 		} else {
-			err = http.ListenAndServeTLS(util.Publicaddress, util.TLSCert, util.TLSKey, r)
+			err = publicServer.ListenAndServeTLS(util.TLSCert, util.TLSKey)
 		}
-		if err != nil {
+		if err != nil && err != http.ErrServerClosed {
 			util.AppService.Log.Fatalln("Error while listening:", err)
 			os.Exit(1)
 		}
@@ -108,92 +124,183 @@ func prepareRun() error {
 	return nil
 }
 
-// Run starts the actual Appservice to let it listen to both ends
+// Run starts the actual Appservice to let it listen to both ends until SIGINT or SIGTERM and shuts it down afterwards
 func Run() error {
-	err := prepareRun()
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	err := prepareRun(ctx)
 	if err != nil {
 		return err
 	}
 
+	if util.MessageRetention > 0 || util.MediaExpiry > 0 {
+		go pruneDB(ctx)
+	}
+
 	util.AppService.Log.Debugln("Start Connecting BotUser to Twitch as: ", util.BotUser.TwitchName)
 
 	util.AppService.Log.Debugln("Start letting BotUser listen to Twitch")
 
-	for _, v := range queryHandler.QueryHandler().Aliases {
-		util.BotUser.Mux.Lock()
-		v.TwitchWS = &wsImpl.WebsocketHolder{
-			Done:        make(chan struct{}),
-			TwitchRooms: queryHandler.QueryHandler().TwitchRooms,
-			TwitchUsers: queryHandler.QueryHandler().TwitchUsers,
-			RealUsers:   queryHandler.QueryHandler().RealUsers,
-			Users:       queryHandler.QueryHandler().Users,
-			TRoom:       v.TwitchChannel,
-		}
-		err = v.TwitchWS.Connect(util.BotUser.TwitchToken, util.BotUser.TwitchName)
+	qHandler := queryHandler.QueryHandler()
+	qHandler.BotPool = &wsImpl.Pool{
+		TwitchRooms: qHandler.TwitchRooms,
+		Aliases:     qHandler.Aliases,
+		TwitchUsers: qHandler.TwitchUsers,
+		RealUsers:   qHandler.RealUsers,
+		Users:       qHandler.Users,
+		Context:     ctx,
+	}
+	for _, v := range room.ListRooms(qHandler.Aliases) {
+		err = qHandler.BotPool.Join(v.TwitchChannel)
 		if err != nil {
 			return err
 		}
+	}
 
-		v.TwitchWS.Listen()
-		err = v.TwitchWS.Join(v.TwitchChannel)
-		util.BotUser.Mux.Unlock()
+	util.AppService.Log.Debugln("Connecting Real Users to Twitch")
+	for _, v := range user.ListRealUsers(queryHandler.QueryHandler().RealUsers) {
+		if v.TwitchTokenStruct == nil || v.TwitchTokenStruct.AccessToken == "" || v.TwitchName == "" {
+			continue
+		}
+		err = queryHandler.QueryHandler().ConnectRealUser(v, login.TokenFunc(v))
 		if err != nil {
-			return err
+			util.AppService.Log.Errorln(err)
 		}
 	}
 
+	eventLoop := make(chan struct{})
+	stopEvents := make(chan struct{})
 	go func() {
+		defer close(eventLoop)
 		for {
 			select {
 			case e := <-util.AppService.Events:
-				util.AppService.Log.Debugln("Got Event")
-				switch e.Type {
-				case event.StateMember:
-					if e.Content.AsMember().Membership == event.MembershipJoin {
-
-						qHandler := queryHandler.QueryHandler()
-						for _, v := range qHandler.Aliases {
-							if v.ID == e.RoomID.String() {
-								if e.Sender.String() != util.BotUser.MXClient.UserID {
-									err = joinEventHandler(e)
-									if err != nil {
-										util.AppService.Log.Errorln(err)
-									}
-								}
-							}
-						}
-						continue
-
+				handleEvent(e)
+			case <-stopEvents:
+				// The appservice is stopped, so only the events it already accepted are left
+				for {
+					select {
+					case e := <-util.AppService.Events:
+						handleEvent(e)
+					default:
+						return
 					}
-				case event.EventMessage:
-					qHandler := queryHandler.QueryHandler()
-					for _, v := range qHandler.Aliases {
-						if v.ID == e.RoomID.String() {
-							if e.Sender.String() != util.BotUser.MXClient.UserID {
-								err = useEvent(e)
-								if err != nil {
-									util.AppService.Log.Errorln(err)
-								}
-							}
+				}
+			}
+		}
+	}()
+
+	util.AppService.Log.Infoln("Starting Appservice Server...")
+	go util.AppService.Start()
+
+	<-ctx.Done()
+	shutdown(stopEvents, eventLoop)
+	return nil
+}
+
+// handleEvent dispatches an event received by the appservice
+func handleEvent(e *event.Event) {
+	util.AppService.Log.Debugln("Got Event")
+	switch e.Type {
+	case event.StateMember:
+		if e.Content.AsMember().Membership == event.MembershipJoin {
+
+			qHandler := queryHandler.QueryHandler()
+			for _, v := range room.ListRooms(qHandler.Aliases) {
+				if v.ID == e.RoomID.String() {
+					if e.Sender.String() != util.BotUser.MXClient.UserID {
+						err := joinEventHandler(e)
+						if err != nil {
+							util.AppService.Log.Errorln(err)
 						}
 					}
-					continue
+				}
+			}
+			return
 
+		}
+		if e.Content.AsMember().Membership == event.MembershipInvite {
+			err := ghostInviteHandler(e)
+			if err != nil {
+				util.AppService.Log.Errorln(err)
+			}
+			return
+		}
+	case event.EventMessage, event.EventSticker:
+		qHandler := queryHandler.QueryHandler()
+		portal := false
+		for _, v := range room.ListRooms(qHandler.Aliases) {
+			if v.ID == e.RoomID.String() {
+				portal = true
+				if e.Sender.String() != util.BotUser.MXClient.UserID {
+					err := useEvent(e)
+					if err != nil {
+						util.AppService.Log.Errorln(err)
+					}
 				}
 			}
 		}
-	}()
+		if !portal {
+			whisperRoom, err := util.DB.GetWhisperRoomByID(e.RoomID.String())
+			if err != nil {
+				util.AppService.Log.Errorln(err)
+			} else if whisperRoom != nil {
+				err = sendWhisper(whisperRoom, e)
+				if err != nil {
+					util.AppService.Log.Errorln(err)
+				}
+			}
+		}
+		return
+	case event.EventRedaction:
+		qHandler := queryHandler.QueryHandler()
+		for _, v := range room.ListRooms(qHandler.Aliases) {
+			if v.ID == e.RoomID.String() {
+				if e.Sender.String() != util.BotUser.MXClient.UserID {
+					err := redactEvent(v, e)
+					if err != nil {
+						util.AppService.Log.Errorln(err)
+					}
+				}
+			}
+		}
+		return
 
-	util.AppService.Log.Infoln("Starting Appservice Server...")
-	util.AppService.Start()
+	}
+}
 
-	select {}
+// pruneDB removes message mappings older than util.MessageRetention and expired media tokens once an hour until ctx is done
+func pruneDB(ctx context.Context) {
+	for {
+		if util.MessageRetention > 0 {
+			deleted, err := util.DB.PruneMessages(time.Now().Add(-util.MessageRetention))
+			if err != nil {
+				util.AppService.Log.Errorln(err)
+			} else if deleted > 0 {
+				util.AppService.Log.Debugf("Pruned %d message mappings\n", deleted)
+			}
+		}
+		if util.MediaExpiry > 0 {
+			deleted, err := util.DB.PruneMedia(time.Now())
+			if err != nil {
+				util.AppService.Log.Errorln(err)
+			} else if deleted > 0 {
+				util.AppService.Log.Debugf("Pruned %d expired media tokens\n", deleted)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Hour):
+		}
+	}
 }
 
 func joinEventHandler(e *event.Event) error {
 	qHandler := queryHandler.QueryHandler()
-	mxUser := qHandler.RealUsers[e.Sender.String()]
-	asUser := qHandler.Users[e.Sender.String()]
+	mxUser := user.GetRealUser(qHandler.RealUsers, e.Sender.String())
+	asUser := user.GetASUser(qHandler.Users, e.Sender.String())
 	util.AppService.Log.Debugf("AS User: %+v\n", asUser)
 	if asUser != nil || util.BotUser.Mxid == e.Sender.String() {
 		return nil
@@ -201,9 +308,10 @@ func joinEventHandler(e *event.Event) error {
 	if mxUser == nil {
 		util.AppService.Log.Debugln("Creating new User")
 
-		qHandler.RealUsers[e.Sender.String()] = &user.RealUser{}
-		mxUser := qHandler.RealUsers[e.Sender.String()]
-		mxUser.Mxid = e.Sender.String()
+		mxUser, added := user.AddRealUser(qHandler.RealUsers, &user.RealUser{Mxid: e.Sender.String()})
+		if !added {
+			return nil
+		}
 
 		util.AppService.Log.Debugln("Let new User Login")
 		err := login.SendLoginURL(mxUser)
@@ -217,8 +325,8 @@ func joinEventHandler(e *event.Event) error {
 
 func useEvent(e *event.Event) error {
 	qHandler := queryHandler.QueryHandler()
-	mxUser := qHandler.RealUsers[e.Sender.String()]
-	asUser := qHandler.Users[e.Sender.String()]
+	mxUser := user.GetRealUser(qHandler.RealUsers, e.Sender.String())
+	asUser := user.GetASUser(qHandler.Users, e.Sender.String())
 	util.AppService.Log.Debugf("AS User: %+v\n", asUser)
 	if asUser != nil || util.BotUser.Mxid == e.Sender.String() || mxUser == nil {
 		return nil
@@ -227,55 +335,190 @@ func useEvent(e *event.Event) error {
 	util.AppService.Log.Infoln("Processing Event")
 
 	util.AppService.Log.Debugln("Check if Room of e is known")
-	for _, v := range qHandler.Aliases {
+	for _, v := range room.ListRooms(qHandler.Aliases) {
 		if v.ID == e.RoomID.String() {
 
 			util.AppService.Log.Debugln("Check if we have already a open WS")
 			if mxUser.TwitchWS == nil {
 				util.AppService.Log.Debugf("%+v\n", mxUser.TwitchTokenStruct)
 				if mxUser.TwitchTokenStruct != nil && mxUser.TwitchTokenStruct.AccessToken != "" && mxUser.TwitchName != "" {
-					var err error
-
 					util.AppService.Log.Debugln("Connect new WS to Twitch")
-					v.TwitchWS = &wsImpl.WebsocketHolder{
-						Done:        make(chan struct{}),
-						TwitchRooms: queryHandler.QueryHandler().TwitchRooms,
-						TwitchUsers: queryHandler.QueryHandler().TwitchUsers,
-						RealUsers:   queryHandler.QueryHandler().RealUsers,
-						Users:       queryHandler.QueryHandler().Users,
-					}
-					err = v.TwitchWS.Connect(mxUser.TwitchTokenStruct.AccessToken, mxUser.TwitchName)
+					err := qHandler.ConnectRealUser(mxUser, login.TokenFunc(mxUser))
 					if err != nil {
 						return err
 					}
-					v.TwitchWS.Listen()
 				} else {
 					return nil
 				}
 			}
 
 			util.AppService.Log.Debugln("Check if text or other Media")
-			if e.Content.AsMessage().MsgType == event.MsgText {
-				util.AppService.Log.Debugln("Send message to twitch")
-
-				util.BotUser.Mux.Lock()
-				err := v.TwitchWS.Send(v.TwitchChannel, e.Content.AsMessage().Body)
-				util.BotUser.Mux.Unlock()
+			content := e.Content.AsMessage()
+			var message string
+			// editedText is the text of the edited message after a correction got sent
+			var editedText string
+			eventID := e.ID.String()
+			switch {
+			case isEdit(content):
+				var err error
+				message, editedText, err = editMessage(e, content)
+				if err != nil || message == "" {
+					return err
+				}
+				// Corrections belong to the edited message so they get deleted together with it
+				eventID = content.RelatesTo.GetReplaceID().String()
+			case content.MsgType == event.MsgText || content.MsgType == event.MsgEmote:
+				message = formatForTwitch(content)
+			case isMedia(e, content):
+				if !mediaAllowed(v) {
+					return noticeSender(e, "Links aren't allowed in #"+v.TwitchChannel+" so files can't be sent there.")
+				}
+				var err error
+				message, err = mediaMessage(content)
 				if err != nil {
 					return err
 				}
-			} else {
+				if message == "" {
+					return noticeSender(e, "This file can't be sent to Twitch.")
+				}
+			default:
 				util.AppService.Log.Debugln("Send message to bridge Room to tell user to use plain text")
-				resp, err := util.BotUser.MXClient.GetDisplayName(e.Sender.String())
+				return noticeSender(e, "Please use Text only as Twitch doesn't support any other Media Format!")
+			}
+
+			util.AppService.Log.Debugln("Send message to twitch")
+
+			// Replies to bridged messages are sent as native Twitch replies
+			var tags map[string]string
+			if replyTo := content.GetReplyTo(); replyTo != "" {
+				parent, err := util.DB.GetMessageByEventID(replyTo.String())
 				if err != nil {
 					return err
 				}
-				_, err = util.BotUser.MXClient.SendNotice(e.RoomID.String(), resp.DisplayName+": Please use Text only as Twitch doesn't support any other Media Format!")
+				if parent != nil {
+					tags = map[string]string{"reply-parent-msg-id": parent.TwitchID}
+				}
+			}
+
+			// Twitch only accepts single lines of up to 500 characters
+			parts := irc.SplitMessage(message, irc.MaxMessageLength)
+			truncated := false
+			if util.MaxMessageParts > 0 && len(parts) > util.MaxMessageParts {
+				parts = parts[:util.MaxMessageParts]
+				truncated = true
+			}
+
+			mxUser.Mux.Lock()
+			// The puppet joins the channel to get its USERSTATE with the moderator status before the messages get sent
+			err := mxUser.TwitchWS.Join(v.TwitchChannel)
+			if err != nil {
+				mxUser.Mux.Unlock()
+				return err
+			}
+			messages := make([]*websocket.OutgoingMessage, 0, len(parts))
+			for i, text := range parts {
+				if content.MsgType == event.MsgEmote {
+					text = irc.Action(text)
+				}
+				// Only the first part is sent as reply
+				if i > 0 {
+					tags = nil
+				}
+				messages = append(messages, &websocket.OutgoingMessage{
+					Channel: v.TwitchChannel,
+					Text:    text,
+					Tags:    tags,
+					RoomID:  e.RoomID.String(),
+					EventID: eventID,
+					Sender:  e.Sender.String(),
+				})
+			}
+			// All parts get queued together so a full queue never leaves half a message on Twitch
+			err = mxUser.TwitchWS.SendMessage(messages...)
+			if err == websocket.ErrQueueFull {
+				mxUser.Mux.Unlock()
+				return noticeSender(e, "You are sending messages faster than Twitch allows. Please wait a moment, your message was not sent.")
+			}
+			if err != nil {
+				mxUser.Mux.Unlock()
+				return err
+			}
+			mxUser.Mux.Unlock()
+
+			if editedText != "" {
+				err = util.DB.SaveEdit(eventID, editedText)
 				if err != nil {
 					return err
 				}
 			}
+			if truncated {
+				return noticeSender(e, truncatedNotice())
+			}
 		}
 	}
 	return nil
 }
+
+// truncatedNotice tells the sender that the end of a message got dropped after util.MaxMessageParts messages
+func truncatedNotice() string {
+	return fmt.Sprintf("Your message was too long for Twitch and got truncated after %d messages.", util.MaxMessageParts)
+}
+
+// noticeSender sends a notice addressed to the sender of the event into the room of the event
+func noticeSender(e *event.Event, notice string) error {
+	resp, err := util.BotUser.MXClient.GetDisplayName(e.Sender.String())
+	if err != nil {
+		return err
+	}
+	_, err = util.BotUser.MXClient.SendNotice(e.RoomID.String(), resp.DisplayName+": "+notice)
+	return err
+}
+
+// redactEvent deletes the Twitch message of a redacted event using the Twitch account of the redacting user
+func redactEvent(troom *room.Room, e *event.Event) error {
+	mxUser := user.GetRealUser(queryHandler.QueryHandler().RealUsers, e.Sender.String())
+	if mxUser == nil || mxUser.TwitchTokenStruct == nil {
+		return nil
+	}
+
+	// Long messages were sent as multiple Twitch messages which all get deleted
+	messages, err := util.DB.GetMessagesByEventID(e.Redacts.String())
+	if err != nil || len(messages) == 0 {
+		return err
+	}
+
+	util.AppService.Log.Debugln("Delete message on twitch")
+	client := login.HTTPClient(mxUser)
+	broadcasterID := troom.TwitchRoomID
+	if broadcasterID == "" {
+		broadcaster, err := api.GetHelixUser(client, troom.TwitchChannel)
+		if err != nil {
+			return err
+		}
+		broadcasterID = broadcaster.ID
+	}
+	moderator, err := api.GetHelixUser(client, "")
+	if err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		err = api.DeleteChatMessage(client, broadcasterID, moderator.ID, message.TwitchID)
+		if err != nil {
+			break
+		}
+	}
+	if helixErr, ok := err.(*api.HelixError); ok && (helixErr.Status == http.StatusUnauthorized || helixErr.Status == http.StatusForbidden) {
+		resp, err := util.BotUser.MXClient.GetDisplayName(e.Sender.String())
+		if err != nil {
+			return err
+		}
+		notice := resp.DisplayName + ": The message couldn't be deleted on Twitch as you are not a moderator of #" + troom.TwitchChannel + "."
+		if helixErr.Status == http.StatusUnauthorized {
+			notice = resp.DisplayName + ": The message couldn't be deleted on Twitch. Please login again to allow the bridge to delete messages."
+		}
+		_, err = util.BotUser.MXClient.SendNotice(e.RoomID.String(), notice)
+		return err
+	}
+	return err
+}
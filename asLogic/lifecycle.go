@@ -0,0 +1,105 @@
+package asLogic
+
+import (
+	"context"
+	dbHelper "github.com/Nordgedanken/matrix-twitch-bridge/asLogic/db/helper"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/queryHandler"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/websocket"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/user"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout is how long the shutdown waits for the queues, the connections and the servers
+const shutdownTimeout = 15 * time.Second
+
+// publicServer serves the login callback and the media proxy
+var publicServer *http.Server
+
+// signalContext returns a context which is done once the process gets SIGINT or SIGTERM.
+// A second signal kills the process right away.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			log.Println("Got", sig, "- shutting down")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// shutdown stops the bridge in order:
+// The appservice and the public server stop taking requests, the event loop handles the events
+// which were already accepted, the Twitch connections send their queued messages, part all
+// channels and close, and the DB gets closed.
+func shutdown(stopEvents chan<- struct{}, eventLoop <-chan struct{}) {
+	util.AppService.Log.Infoln("Shutting down...")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// The event loop keeps running meanwhile so transactions waiting on a full Events channel can finish
+	util.AppService.Log.Debugln("Stopping Appservice Server")
+	util.AppService.Stop()
+
+	if publicServer != nil {
+		util.AppService.Log.Debugln("Stopping public server")
+		err := publicServer.Shutdown(ctx)
+		if err != nil {
+			util.AppService.Log.Errorln(err)
+		}
+	}
+
+	util.AppService.Log.Debugln("Handling remaining events")
+	close(stopEvents)
+	select {
+	case <-eventLoop:
+	case <-ctx.Done():
+	}
+
+	util.AppService.Log.Debugln("Closing Twitch connections")
+	var wg sync.WaitGroup
+	qHandler := queryHandler.QueryHandler()
+	if qHandler.BotPool != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			qHandler.BotPool.Close(ctx)
+		}()
+	}
+	for _, ruser := range user.ListRealUsers(qHandler.RealUsers) {
+		ruser.Mux.Lock()
+		conn := ruser.TwitchWS
+		ruser.Mux.Unlock()
+		if conn == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(conn websocket.WebsocketHolder) {
+			defer wg.Done()
+			err := conn.Close(ctx)
+			if err != nil {
+				util.AppService.Log.Errorln(err)
+			}
+		}(conn)
+	}
+	wg.Wait()
+
+	util.AppService.Log.Debugln("Closing DB")
+	err := dbHelper.Close()
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+	}
+	util.AppService.Log.Infoln("Shutdown done")
+}
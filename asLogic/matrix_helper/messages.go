@@ -0,0 +1,42 @@
+package matrix_helper
+
+// MessageContent is the content of a m.room.message event sent by the bridge
+type MessageContent struct {
+	MsgType       string     `json:"msgtype"`
+	Body          string     `json:"body"`
+	Format        string     `json:"format,omitempty"`
+	FormattedBody string     `json:"formatted_body,omitempty"`
+	RelatesTo     *RelatesTo `json:"m.relates_to,omitempty"`
+	// Mentions lists the mentioned users so their clients highlight the message
+	Mentions *Mentions `json:"m.mentions,omitempty"`
+}
+
+// Mentions holds the users a message mentions
+type Mentions struct {
+	UserIDs []string `json:"user_ids,omitempty"`
+}
+
+// RelatesTo describes the relation of a message to another event
+type RelatesTo struct {
+	InReplyTo *InReplyTo `json:"m.in_reply_to,omitempty"`
+}
+
+// InReplyTo references the event a message replies to
+type InReplyTo struct {
+	EventID string `json:"event_id"`
+}
+
+// SetMentions lists the users as mentioned. Nothing gets set if there are none
+func (c *MessageContent) SetMentions(userIDs []string) {
+	if len(userIDs) == 0 {
+		return
+	}
+	c.Mentions = &Mentions{UserIDs: userIDs}
+}
+
+// SetReply marks the message as reply to the given event
+func (c *MessageContent) SetReply(eventID string) {
+	c.RelatesTo = &RelatesTo{
+		InReplyTo: &InReplyTo{EventID: eventID},
+	}
+}
@@ -1,6 +1,10 @@
 package matrix_helper
 
-import "github.com/matrix-org/gomatrix"
+import (
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/user"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"github.com/matrix-org/gomatrix"
+)
 
 func CreateRoom(client *gomatrix.Client, displayname, avatarURL, alias, preset string, direct bool) (*gomatrix.RespCreateRoom, error) {
 	createRoomReq := &gomatrix.ReqCreateRoom{}
@@ -32,3 +36,34 @@ func CreateRoom(client *gomatrix.Client, displayname, avatarURL, alias, preset s
 	}
 	return roomResp, nil
 }
+
+// EnsureBotRoom creates the room between the Bot and the Real User if needed and makes sure the Real User is invited
+func EnsureBotRoom(ruser *user.RealUser) error {
+	if ruser.Room == "" {
+		resp, err := CreateRoom(util.BotUser.MXClient, "Twitch Bot", "", "", "trusted_private_chat", true)
+		if err != nil {
+			return err
+		}
+		ruser.Room = resp.RoomID
+	}
+
+	joinedResp, err := util.BotUser.MXClient.JoinedMembers(ruser.Room)
+	if err != nil {
+		return err
+	}
+	if _, ok := joinedResp.Joined[ruser.Mxid]; !ok {
+		// Workaround gomatrix bug
+
+		inviteReq := &gomatrix.ReqInviteUser{
+			UserID: ruser.Mxid,
+		}
+
+		u := util.BotUser.MXClient.BuildURL("rooms", ruser.Room, "invite")
+		resp := &gomatrix.RespInviteUser{}
+		err = util.BotUser.MXClient.MakeRequest("POST", u, inviteReq, &resp)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
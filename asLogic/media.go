@@ -0,0 +1,164 @@
+package asLogic
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/room"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"github.com/gorilla/mux"
+	"io"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// mediaClient fetches the media from the homeserver for the media proxy
+var mediaClient = &http.Client{Timeout: time.Minute}
+
+// mediaHeaders are the headers of the homeserver response which get passed through by the media proxy
+var mediaHeaders = []string{"Content-Type", "Content-Length", "Last-Modified"}
+
+// inlineMediaTypes are the content types the media proxy lets browsers display.
+// Everything else gets downloaded as the proxy shares its origin with the login callback.
+var inlineMediaTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+	"video/mp4":  true,
+	"video/webm": true,
+}
+
+// isMedia checks if the event is a file of any kind which can be bridged as link
+func isMedia(e *event.Event, content *event.MessageEventContent) bool {
+	if e.Type == event.EventSticker {
+		return true
+	}
+	switch content.MsgType {
+	case event.MsgImage, event.MsgVideo, event.MsgAudio, event.MsgFile:
+		return true
+	}
+	return false
+}
+
+// mediaAllowed checks if links may be posted in the Twitch channel of the portal.
+// The MediaEventType state event of the portal wins over util.NoMediaChannels.
+func mediaAllowed(troom *room.Room) bool {
+	var settings room.MediaSettings
+	err := util.BotUser.MXClient.StateEvent(troom.ID, room.MediaEventType, "", &settings)
+	if err == nil {
+		return settings.Allowed
+	}
+
+	for _, v := range util.NoMediaChannels {
+		if strings.EqualFold(strings.TrimPrefix(v, "#"), troom.TwitchChannel) {
+			return false
+		}
+	}
+	return true
+}
+
+// mediaMessage returns the text for Twitch with the caption of the media and a link to the media proxy.
+// It returns an empty string if the media can't be proxied like encrypted files.
+func mediaMessage(content *event.MessageEventContent) (string, error) {
+	if content.URL == "" {
+		return "", nil
+	}
+	mxc, err := content.URL.Parse()
+	if err != nil {
+		return "", err
+	}
+
+	tokenBytes := make([]byte, 16)
+	_, err = rand.Read(tokenBytes)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	var expires time.Time
+	if util.MediaExpiry > 0 {
+		expires = time.Now().Add(util.MediaExpiry)
+	}
+	err = util.DB.SaveMedia(token, mxc.String(), expires)
+	if err != nil {
+		return "", err
+	}
+
+	link := "https://" + util.Publicaddress + "/media/" + token
+	caption := strings.TrimSpace(content.Body)
+	if caption == "" {
+		return link, nil
+	}
+	return caption + " " + link, nil
+}
+
+// MediaProxy serves the Matrix media a token of mediaMessage points to
+func MediaProxy(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	mxc, err := util.DB.GetMedia(token)
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if mxc == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	uri, err := id.ParseContentURI(mxc)
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Downloads need authentication since Matrix v1.11 so the legacy unauthenticated endpoint can't be used
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, strings.TrimSuffix(util.AppService.HomeserverURL, "/")+"/_matrix/client/v1/media/download/"+url.PathEscape(uri.Homeserver)+"/"+url.PathEscape(uri.FileID), nil)
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+util.AppService.Registration.AppToken)
+	res, err := mediaClient.Do(req)
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+
+	for _, header := range mediaHeaders {
+		if value := res.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	// Uploads must never run as page on our origin
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mediaDisposition(res.Header.Get("Content-Type"), res.Header.Get("Content-Disposition")))
+	w.WriteHeader(res.StatusCode)
+	_, err = io.Copy(w, res.Body)
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+	}
+}
+
+// mediaDisposition returns the Content-Disposition for the proxied media.
+// Only inlineMediaTypes are shown inline, everything else becomes an attachment. The filename of the homeserver is kept.
+func mediaDisposition(contentType, disposition string) string {
+	dispositionType := "attachment"
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && inlineMediaTypes[mediaType] {
+		dispositionType = "inline"
+	}
+	params := map[string]string{}
+	if _, original, err := mime.ParseMediaType(disposition); err == nil && original["filename"] != "" {
+		params["filename"] = original["filename"]
+	}
+	return mime.FormatMediaType(dispositionType, params)
+}
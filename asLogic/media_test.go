@@ -0,0 +1,24 @@
+package asLogic
+
+import "testing"
+
+func TestMediaDisposition(t *testing.T) {
+	tests := []struct {
+		contentType string
+		disposition string
+		want        string
+	}{
+		{"image/png", `inline; filename="cat.png"`, "inline; filename=cat.png"},
+		{"video/mp4", "", "inline"},
+		{"text/html; charset=utf-8", `inline; filename="page.html"`, "attachment; filename=page.html"},
+		{"image/svg+xml", "inline", "attachment"},
+		{"", "", "attachment"},
+	}
+
+	for _, tt := range tests {
+		got := mediaDisposition(tt.contentType, tt.disposition)
+		if got != tt.want {
+			t.Errorf("mediaDisposition(%q, %q) = %q, want %q", tt.contentType, tt.disposition, got, tt.want)
+		}
+	}
+}
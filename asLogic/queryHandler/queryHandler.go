@@ -1,6 +1,7 @@
 package queryHandler
 
 import (
+	"context"
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/matrix_helper"
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/room"
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/api"
@@ -20,6 +21,10 @@ type queryHandler struct {
 	TwitchUsers map[string]*user.ASUser
 	Aliases     map[string]*room.Room
 	TwitchRooms map[string]string
+	// BotPool holds the connections the Bot uses to listen to the channels
+	BotPool *implementation.Pool
+	// Context is done once the bridge shuts down
+	Context context.Context
 }
 
 var queryHandlerVar *queryHandler
@@ -35,7 +40,7 @@ func QueryHandler() *queryHandler {
 // QueryAlias is the logic that creates if needed a AS managed matrix room
 // and tells the Homeserver if that room alias is managed by the AS
 func (q queryHandler) QueryAlias(alias string) bool {
-	if q.Aliases[alias] != nil {
+	if room.GetRoom(q.Aliases, alias) != nil {
 		return true
 	}
 	var tUsername string
@@ -81,25 +86,13 @@ func (q queryHandler) QueryAlias(alias string) bool {
 		ID:            resp.RoomID,
 		TwitchChannel: tUsername,
 	}
-	q.Aliases[alias] = troom
-	q.TwitchRooms[troom.TwitchChannel] = troom.ID
+	room.AddRoom(q.Aliases, q.TwitchRooms, troom)
 	err = util.DB.SaveRoom(troom)
 	if err != nil {
 		util.AppService.Log.Errorln(err)
 	}
 
-	util.BotUser.Mux.Lock()
-	q.Aliases[alias].TwitchWS = &implementation.WebsocketHolder{
-		Done:        make(chan struct{}),
-		TwitchRooms: q.TwitchRooms,
-		TwitchUsers: q.TwitchUsers,
-		RealUsers:   q.RealUsers,
-		Users:       q.Users,
-		TRoom:       tUsername,
-	}
-	err = q.Aliases[alias].TwitchWS.Connect(util.BotUser.TwitchToken, util.BotUser.TwitchName)
-	err = q.Aliases[alias].TwitchWS.Join(tUsername)
-	util.BotUser.Mux.Unlock()
+	err = q.BotPool.Join(tUsername)
 	if err != nil {
 		util.AppService.Log.Errorln(err)
 	}
@@ -109,7 +102,7 @@ func (q queryHandler) QueryAlias(alias string) bool {
 // QueryUser is the logic that creates if needed a AS managed user
 // and tells the Homeserver if that userID is managed by the AS
 func (q queryHandler) QueryUser(userID string) bool {
-	if q.Users[userID] != nil {
+	if user.GetASUser(q.Users, userID) != nil {
 		return true
 	}
 	var tUsername string
@@ -120,21 +113,28 @@ func (q queryHandler) QueryUser(userID string) bool {
 			return false
 		}
 		if r.MatchString(userID) {
-			tUsername = r.FindStringSubmatch(userID)[0]
+			// name magic
+			pre := strings.Split(v.Regex, ".+")[0]
+			suff := strings.Split(v.Regex, ".+")[1]
+			tUsername = strings.TrimSuffix(strings.TrimPrefix(userID, pre), suff)
 			break
 		}
 	}
+	if tUsername == "" {
+		return false
+	}
 
-	check, err := api.CheckTwitchUser(tUsername)
+	twitchUser, err := api.GetHelixUser(api.BotClient(), tUsername)
 	if err != nil {
 		util.AppService.Log.Errorln(err)
 		return false
 	}
-	if !check {
+	if !strings.EqualFold(twitchUser.Login, tUsername) {
 		return false
 	}
 	asUser := user.ASUser{}
 	asUser.Mxid = userID
+	asUser.TwitchName = tUsername
 	client, err := gomatrix.NewClient(util.AppService.HomeserverURL, userID, util.AppService.Registration.AppToken)
 	if err != nil {
 		util.AppService.Log.Errorln(err)
@@ -150,30 +150,62 @@ func (q queryHandler) QueryUser(userID string) bool {
 	}
 
 	client.AppServiceUserID = userID
-	userdata, err := api.RequestUserData(tUsername)
+	err = client.SetDisplayName(twitchUser.DisplayName + " (Twitch)")
 	if err != nil {
 		util.AppService.Log.Errorln(err)
-		return false
+	} else {
+		asUser.DisplayName = twitchUser.DisplayName
 	}
-	if userdata.Total == 0 {
-		util.AppService.Log.Errorln("user missing")
-		return false
+	if twitchUser.ProfileImageURL != "" {
+		resp, err := client.UploadLink(twitchUser.ProfileImageURL)
+		if err != nil {
+			util.AppService.Log.Errorln(err)
+		} else {
+			err = client.SetAvatarURL(resp.ContentURI)
+			if err != nil {
+				util.AppService.Log.Errorln(err)
+			}
+		}
 	}
-	err = client.SetDisplayName(userdata.Users[0].DisplayName + " (Twitch)")
-	if err != nil {
-		util.AppService.Log.Errorln(err)
+
+	stored, added := user.AddASUser(q.Users, q.TwitchUsers, &asUser)
+	if !added {
+		return stored.Mxid == userID
 	}
-	resp, err := client.UploadLink(userdata.Users[0].Logo)
-	err = client.SetAvatarURL(resp.ContentURI)
+	err = util.DB.SaveUser(stored)
 	if err != nil {
 		util.AppService.Log.Errorln(err)
+		return false
+	}
+	return true
+}
+
+// ConnectRealUser opens the Twitch connection of a Real User which is used to send messages in their name.
+// token gets called on every (re)connect to get a valid access token.
+func (q queryHandler) ConnectRealUser(ruser *user.RealUser, token func() (string, error)) error {
+	ruser.Mux.Lock()
+	defer ruser.Mux.Unlock()
+
+	if old, ok := ruser.TwitchWS.(*implementation.WebsocketHolder); ok {
+		// Replace the connection of an earlier login
+		go old.Close(q.Context)
 	}
 
-	q.Users[userID] = &asUser
-	err = util.DB.SaveUser(q.Users[userID])
+	ws := &implementation.WebsocketHolder{
+		Done:        make(chan struct{}),
+		TwitchRooms: q.TwitchRooms,
+		Aliases:     q.Aliases,
+		TwitchUsers: q.TwitchUsers,
+		RealUsers:   q.RealUsers,
+		Users:       q.Users,
+		Owner:       ruser.Mxid,
+		Username:    ruser.TwitchName,
+		Token:       token,
+	}
+	err := ws.Connect(q.Context)
 	if err != nil {
-		util.AppService.Log.Errorln(err)
-		return false
+		return err
 	}
-	return true
+	ruser.TwitchWS = ws
+	return nil
 }
@@ -0,0 +1,13 @@
+package room
+
+import "time"
+
+// Message maps a Twitch message to the Matrix event it got bridged as
+type Message struct {
+	TwitchID string
+	EventID  string
+	RoomID   string
+	// Sender holds the MXID of the User that sent the Matrix event
+	Sender    string
+	Timestamp time.Time
+}
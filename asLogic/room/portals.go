@@ -0,0 +1,39 @@
+package room
+
+import "sync"
+
+// portalsMux guards the maps of portal rooms which the queryHandler and all Twitch connections share
+var portalsMux sync.RWMutex
+
+// GetRoom returns the portal room stored under the alias or nil
+func GetRoom(aliases map[string]*Room, alias string) *Room {
+	portalsMux.RLock()
+	defer portalsMux.RUnlock()
+	return aliases[alias]
+}
+
+// GetRoomID returns the Matrix room ID of the portal room for the Twitch channel or an empty string
+func GetRoomID(twitchRooms map[string]string, channel string) string {
+	portalsMux.RLock()
+	defer portalsMux.RUnlock()
+	return twitchRooms[channel]
+}
+
+// AddRoom stores the portal room by alias and its room ID by Twitch channel
+func AddRoom(aliases map[string]*Room, twitchRooms map[string]string, troom *Room) {
+	portalsMux.Lock()
+	defer portalsMux.Unlock()
+	aliases[troom.Alias] = troom
+	twitchRooms[troom.TwitchChannel] = troom.ID
+}
+
+// ListRooms returns a snapshot of the portal rooms which can be ranged over without holding the lock
+func ListRooms(aliases map[string]*Room) []*Room {
+	portalsMux.RLock()
+	defer portalsMux.RUnlock()
+	rooms := make([]*Room, 0, len(aliases))
+	for _, v := range aliases {
+		rooms = append(rooms, v)
+	}
+	return rooms
+}
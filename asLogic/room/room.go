@@ -1,11 +1,36 @@
 package room
 
-import "github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/websocket"
-
 // Room contains the required Information about a Room
 type Room struct {
 	Alias         string
 	ID            string
 	TwitchChannel string
-	TwitchWS      websocket.WebsocketHolder
+	// TwitchRoomID is the Twitch user id of the channel owner
+	TwitchRoomID string
+	// State holds the chat settings of the Twitch channel
+	State State
+}
+
+// State contains the chat settings Twitch sends with a ROOMSTATE.
+// It is also used as content of the StateEventType state event.
+type State struct {
+	EmoteOnly bool `json:"emote_only"`
+	// FollowersOnly is -1 if disabled or else the minutes a user needs to follow before chatting
+	FollowersOnly int  `json:"followers_only"`
+	R9K           bool `json:"r9k"`
+	// Slow is the number of seconds a user needs to wait between two messages
+	Slow     int  `json:"slow"`
+	SubsOnly bool `json:"subs_only"`
 }
+
+// StateEventType is the type of the state event the chat settings get exposed as in the portal room
+const StateEventType = "de.nordgedanken.twitch.roomstate"
+
+// MediaSettings is the content of the MediaEventType state event.
+// Room admins set it to decide if Matrix media gets posted as links to the Twitch channel.
+type MediaSettings struct {
+	Allowed bool `json:"allowed"`
+}
+
+// MediaEventType is the type of the state event which configures media bridging per portal room
+const MediaEventType = "de.nordgedanken.twitch.media"
@@ -0,0 +1,10 @@
+package room
+
+// WhisperRoom is a DM between a Real User and a Twitch user in which messages get bridged as whispers
+type WhisperRoom struct {
+	ID string
+	// Mxid is the MXID of the Real User whose Twitch account sends and receives the whispers
+	Mxid string
+	// TwitchName is the login of the Twitch user on the other side
+	TwitchName string
+}
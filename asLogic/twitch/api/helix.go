@@ -0,0 +1,123 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const helixURL = "https://api.twitch.tv/helix"
+
+// botTransport authorizes requests with the token of the Bot
+type botTransport struct{}
+
+func (botTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+util.BotUser.TwitchToken)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// BotClient returns a client which sends Helix requests with the token of the Bot.
+// It is used for lookups which don't need a Real User like resolving a login.
+func BotClient() *http.Client {
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: botTransport{},
+	}
+}
+
+// HelixUser defines the json of a user returned by `https://api.twitch.tv/helix/users`
+type HelixUser struct {
+	ID              string `json:"id"`
+	Login           string `json:"login"`
+	DisplayName     string `json:"display_name"`
+	ProfileImageURL string `json:"profile_image_url"`
+}
+
+// HelixError is returned if the Helix API answers with an error status
+type HelixError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func (e *HelixError) Error() string {
+	return fmt.Sprintf("helix: %d %s", e.Status, e.Message)
+}
+
+// doHelix sends a request to the Helix API. The client needs to add the Authorization of the user itself,
+// which the oauth2 clients of the Real Users do.
+func doHelix(client *http.Client, method, path string, query url.Values, body io.Reader, result interface{}) error {
+	req, err := http.NewRequest(method, helixURL+path+"?"+query.Encode(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Client-Id", util.ClientID)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		helixErr := &HelixError{}
+		// The error body is optional so a failed decode still returns the status
+		_ = json.NewDecoder(res.Body).Decode(helixErr)
+		helixErr.Status = res.StatusCode
+		return helixErr
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+// GetHelixUser returns a Twitch user by login name or the owner of the token if login is empty
+func GetHelixUser(client *http.Client, login string) (*HelixUser, error) {
+	query := url.Values{}
+	if login != "" {
+		query.Set("login", login)
+	}
+	resp := &struct {
+		Data []*HelixUser `json:"data"`
+	}{}
+	err := doHelix(client, http.MethodGet, "/users", query, nil, resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("twitch user %s not found", login)
+	}
+	return resp.Data[0], nil
+}
+
+// SendWhisper sends a whisper from one Twitch user to another. The client needs to belong to the sender
+// with the user:manage:whispers scope.
+func SendWhisper(client *http.Client, fromID, toID, message string) error {
+	query := url.Values{}
+	query.Set("from_user_id", fromID)
+	query.Set("to_user_id", toID)
+	body, err := json.Marshal(map[string]string{"message": message})
+	if err != nil {
+		return err
+	}
+	return doHelix(client, http.MethodPost, "/whispers", query, bytes.NewReader(body), nil)
+}
+
+// DeleteChatMessage deletes a message in the chat of the broadcaster. The client needs to belong to a moderator
+// with the moderator:manage:chat_messages scope.
+func DeleteChatMessage(client *http.Client, broadcasterID, moderatorID, messageID string) error {
+	query := url.Values{}
+	query.Set("broadcaster_id", broadcasterID)
+	query.Set("moderator_id", moderatorID)
+	query.Set("message_id", messageID)
+	return doHelix(client, http.MethodDelete, "/moderation/chat", query, nil, nil)
+}
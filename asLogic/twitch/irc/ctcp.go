@@ -0,0 +1,22 @@
+package irc
+
+import "strings"
+
+const (
+	ctcpDelim    = "\x01"
+	actionPrefix = ctcpDelim + "ACTION "
+)
+
+// ParseAction returns the text of a CTCP ACTION ("/me") message and true,
+// or the unchanged text and false if it isn't an action
+func ParseAction(text string) (string, bool) {
+	if !strings.HasPrefix(text, actionPrefix) {
+		return text, false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(text, actionPrefix), ctcpDelim), true
+}
+
+// Action wraps the text into a CTCP ACTION which Twitch shows like a "/me" message
+func Action(text string) string {
+	return actionPrefix + text + ctcpDelim
+}
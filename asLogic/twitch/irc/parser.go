@@ -0,0 +1,204 @@
+// Package irc implements a parser for the IRCv3 lines Twitch sends over its chat WebSocket.
+//
+// Lines look like this (tags and prefix are optional):
+//
+//	@badge-info=;badges=broadcaster/1;color=#D2691E;display-name=MTRNord;emotes=;id=3e969619-5312-4999-ba21-6d0ab81af8f5;mod=0;room-id=36031510;subscriber=0;tmi-sent-ts=1523458219318;turbo=0;user-id=36031510;user-type= :mtrnord!mtrnord@mtrnord.tmi.twitch.tv PRIVMSG #mtrnord :test
+//	:tmi.twitch.tv 001 mtrnord :Welcome, GLHF!
+//	PING :tmi.twitch.tv
+package irc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Prefix is the source of a Message split into its parts
+type Prefix struct {
+	Nick string
+	User string
+	Host string
+}
+
+// Message is a single parsed IRC line
+type Message struct {
+	// Tags holds the IRCv3 tags with already unescaped values
+	Tags    map[string]string
+	Prefix  Prefix
+	Command string
+	// Params holds all parameters. The trailing parameter (if any) is the last element
+	Params []string
+	// Raw is the line the Message got parsed from
+	Raw string
+}
+
+// Split splits a WebSocket frame into the single IRC lines it contains.
+// Twitch batches multiple "\r\n" separated lines into one frame.
+func Split(frame string) []string {
+	var lines []string
+	for _, line := range strings.Split(frame, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Parse parses a single IRC line as described in https://ircv3.net/specs/extensions/message-tags
+func Parse(line string) (*Message, error) {
+	msg := &Message{
+		Tags: make(map[string]string),
+		Raw:  line,
+	}
+	rest := strings.TrimRight(line, "\r\n")
+
+	if strings.HasPrefix(rest, "@") {
+		var rawTags string
+		rawTags, rest = cut(rest[1:])
+		for _, tag := range strings.Split(rawTags, ";") {
+			if tag == "" {
+				continue
+			}
+			kv := strings.SplitN(tag, "=", 2)
+			if len(kv) == 2 {
+				msg.Tags[kv[0]] = UnescapeTagValue(kv[1])
+			} else {
+				msg.Tags[kv[0]] = ""
+			}
+		}
+	}
+
+	if strings.HasPrefix(rest, ":") {
+		var rawPrefix string
+		rawPrefix, rest = cut(rest[1:])
+		msg.Prefix = parsePrefix(rawPrefix)
+	}
+
+	msg.Command, rest = cut(rest)
+	if msg.Command == "" {
+		return nil, fmt.Errorf("irc: missing command in line %q", line)
+	}
+	msg.Command = strings.ToUpper(msg.Command)
+
+	for rest != "" {
+		if strings.HasPrefix(rest, ":") {
+			msg.Params = append(msg.Params, rest[1:])
+			break
+		}
+		var param string
+		param, rest = cut(rest)
+		msg.Params = append(msg.Params, param)
+	}
+
+	return msg, nil
+}
+
+// Param returns the parameter at index i or an empty string if it doesn't exist
+func (m *Message) Param(i int) string {
+	if i < 0 || i >= len(m.Params) {
+		return ""
+	}
+	return m.Params[i]
+}
+
+// Trailing returns the last parameter which usually is the message text
+func (m *Message) Trailing() string {
+	return m.Param(len(m.Params) - 1)
+}
+
+// Channel returns the channel name without the leading "#" if the first parameter is a channel
+func (m *Message) Channel() string {
+	if strings.HasPrefix(m.Param(0), "#") {
+		return strings.TrimPrefix(m.Param(0), "#")
+	}
+	return ""
+}
+
+// cut returns the next space separated token and the remainder with leading spaces removed
+func cut(s string) (token, rest string) {
+	s = strings.TrimLeft(s, " ")
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimLeft(s[i+1:], " ")
+}
+
+func parsePrefix(raw string) (p Prefix) {
+	if i := strings.IndexByte(raw, '@'); i >= 0 {
+		p.Host = raw[i+1:]
+		raw = raw[:i]
+	}
+	if i := strings.IndexByte(raw, '!'); i >= 0 {
+		p.User = raw[i+1:]
+		raw = raw[:i]
+	}
+	// A prefix without "!" and "@" is a server name like "tmi.twitch.tv"
+	if p.User == "" && p.Host == "" && strings.Contains(raw, ".") {
+		p.Host = raw
+		return
+	}
+	p.Nick = raw
+	return
+}
+
+// UnescapeTagValue reverts the escaping of IRCv3 tag values
+func UnescapeTagValue(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		if i >= len(value) {
+			// A trailing lone backslash gets dropped
+			break
+		}
+		switch value[i] {
+		case ':':
+			b.WriteByte(';')
+		case 's':
+			b.WriteByte(' ')
+		case '\\':
+			b.WriteByte('\\')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String()
+}
+
+// EscapeTagValue escapes a tag value so it can be sent in an IRCv3 line
+func EscapeTagValue(value string) string {
+	return tagEscaper.Replace(value)
+}
+
+var tagEscaper = strings.NewReplacer("\\", "\\\\", ";", "\\:", " ", "\\s", "\r", "\\r", "\n", "\\n")
+
+// FormatTags returns the tags as "@key=value;key2=value2 " prefix for an outgoing line or an empty string if there are none
+func FormatTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	formatted := make([]string, 0, len(keys))
+	for _, k := range keys {
+		formatted = append(formatted, k+"="+EscapeTagValue(tags[k]))
+	}
+	return "@" + strings.Join(formatted, ";") + " "
+}
@@ -0,0 +1,131 @@
+package irc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want *Message
+	}{
+		{
+			name: "tagged PRIVMSG",
+			line: `@badge-info=;badges=broadcaster/1;color=#D2691E;display-name=MTRNord;system-msg=a\sb\:c\\d;user-type= :mtrnord!mtrnord@mtrnord.tmi.twitch.tv PRIVMSG #mtrnord :test message`,
+			want: &Message{
+				Tags: map[string]string{
+					"badge-info":   "",
+					"badges":       "broadcaster/1",
+					"color":        "#D2691E",
+					"display-name": "MTRNord",
+					"system-msg":   `a b;c\d`,
+					"user-type":    "",
+				},
+				Prefix:  Prefix{Nick: "mtrnord", User: "mtrnord", Host: "mtrnord.tmi.twitch.tv"},
+				Command: "PRIVMSG",
+				Params:  []string{"#mtrnord", "test message"},
+			},
+		},
+		{
+			name: "PING without prefix",
+			line: "PING :tmi.twitch.tv",
+			want: &Message{
+				Tags:    map[string]string{},
+				Command: "PING",
+				Params:  []string{"tmi.twitch.tv"},
+			},
+		},
+		{
+			name: "server prefix",
+			line: ":tmi.twitch.tv 001 mtrnord :Welcome, GLHF!",
+			want: &Message{
+				Tags:    map[string]string{},
+				Prefix:  Prefix{Host: "tmi.twitch.tv"},
+				Command: "001",
+				Params:  []string{"mtrnord", "Welcome, GLHF!"},
+			},
+		},
+		{
+			name: "ROOMSTATE with only a channel",
+			line: "@emote-only=0;room-id=36031510;slow=0 :tmi.twitch.tv ROOMSTATE #mtrnord",
+			want: &Message{
+				Tags:    map[string]string{"emote-only": "0", "room-id": "36031510", "slow": "0"},
+				Prefix:  Prefix{Host: "tmi.twitch.tv"},
+				Command: "ROOMSTATE",
+				Params:  []string{"#mtrnord"},
+			},
+		},
+		{
+			name: "CLEARCHAT with trailing parameter",
+			line: "@ban-duration=600;room-id=36031510;target-user-id=1337 :tmi.twitch.tv CLEARCHAT #mtrnord :spammer",
+			want: &Message{
+				Tags:    map[string]string{"ban-duration": "600", "room-id": "36031510", "target-user-id": "1337"},
+				Prefix:  Prefix{Host: "tmi.twitch.tv"},
+				Command: "CLEARCHAT",
+				Params:  []string{"#mtrnord", "spammer"},
+			},
+		},
+		{
+			name: "NAMES reply",
+			line: ":mtrnord.tmi.twitch.tv 353 mtrnord = #mtrnord :mtrnord foo bar",
+			want: &Message{
+				Tags:    map[string]string{},
+				Prefix:  Prefix{Host: "mtrnord.tmi.twitch.tv"},
+				Command: "353",
+				Params:  []string{"mtrnord", "=", "#mtrnord", "mtrnord foo bar"},
+			},
+		},
+		{
+			name: "end of NAMES",
+			line: ":mtrnord.tmi.twitch.tv 366 mtrnord #mtrnord :End of /NAMES list",
+			want: &Message{
+				Tags:    map[string]string{},
+				Prefix:  Prefix{Host: "mtrnord.tmi.twitch.tv"},
+				Command: "366",
+				Params:  []string{"mtrnord", "#mtrnord", "End of /NAMES list"},
+			},
+		},
+		{
+			name: "empty trailing parameter",
+			line: "PRIVMSG #a :",
+			want: &Message{
+				Tags:    map[string]string{},
+				Command: "PRIVMSG",
+				Params:  []string{"#a", ""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.line)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %s", tt.line, err)
+			}
+			tt.want.Raw = tt.line
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q)\n got %+v\nwant %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, line := range []string{"", "@a=b"} {
+		_, err := Parse(line)
+		if err == nil {
+			t.Errorf("Parse(%q) didn't return an error", line)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	frame := "PING :tmi.twitch.tv\r\n:tmi.twitch.tv 001 mtrnord :Welcome, GLHF!\r\n\r\nPRIVMSG #a :b\n"
+	want := []string{"PING :tmi.twitch.tv", ":tmi.twitch.tv 001 mtrnord :Welcome, GLHF!", "PRIVMSG #a :b"}
+	got := Split(frame)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Split(%q) = %q, want %q", frame, got, want)
+	}
+}
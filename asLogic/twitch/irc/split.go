@@ -0,0 +1,45 @@
+package irc
+
+import (
+	"strings"
+	"unicode"
+)
+
+// MaxMessageLength is the maximum number of characters Twitch accepts in a single PRIVMSG
+const MaxMessageLength = 500
+
+// Sanitize replaces CR and LF with spaces. A line break in a PRIVMSG would end the line and
+// send the remaining text as a raw IRC command.
+func Sanitize(text string) string {
+	return strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(text)
+}
+
+// SplitMessage splits the text into messages of at most maxLength characters.
+// Every line becomes its own message and lines which are too long are split on word boundaries.
+// Words longer than maxLength get split in the middle.
+func SplitMessage(text string, maxLength int) []string {
+	var parts []string
+	for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == '\r' || r == '\n' }) {
+		words := strings.FieldsFunc(line, unicode.IsSpace)
+		current := []rune{}
+		for _, word := range words {
+			runes := []rune(word)
+			if len(current) > 0 && len(current)+1+len(runes) > maxLength {
+				parts = append(parts, string(current))
+				current = []rune{}
+			}
+			for len(runes) > maxLength {
+				parts = append(parts, string(runes[:maxLength]))
+				runes = runes[maxLength:]
+			}
+			if len(current) > 0 {
+				current = append(current, ' ')
+			}
+			current = append(current, runes...)
+		}
+		if len(current) > 0 {
+			parts = append(parts, string(current))
+		}
+	}
+	return parts
+}
@@ -0,0 +1,43 @@
+package irc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		maxLength int
+		want      []string
+	}{
+		{"short", "hello world", 500, []string{"hello world"}},
+		{"lines", "first line\r\nsecond line\n\nthird", 500, []string{"first line", "second line", "third"}},
+		{"word boundary", "aaa bbb ccc", 7, []string{"aaa bbb", "ccc"}},
+		{"long word", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"long word after text", "ab cdefghij", 4, []string{"ab", "cdef", "ghij"}},
+		{"runes", "äöü äöü", 3, []string{"äöü", "äöü"}},
+		{"collapses spaces", "a   b\tc", 500, []string{"a b c"}},
+		{"empty", " \n ", 500, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SplitMessage(tt.text, tt.maxLength)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitMessage(%q, %d) = %q, want %q", tt.text, tt.maxLength, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitMessageMaxLength(t *testing.T) {
+	text := strings.Repeat("word ", 300)
+	for _, part := range SplitMessage(text, MaxMessageLength) {
+		if len([]rune(part)) > MaxMessageLength {
+			t.Errorf("part is %d characters long", len([]rune(part)))
+		}
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/queryHandler"
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/user"
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
-	"github.com/matrix-org/gomatrix"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/twitch"
 	"io/ioutil"
@@ -17,45 +16,57 @@ import (
 
 var conf *oauth2.Config
 
-func SendLoginURL(ruser *user.RealUser) error {
+// config returns the oauth2 config of the Twitch App
+func config() *oauth2.Config {
 	if conf == nil {
 		conf = &oauth2.Config{
 			ClientID:     util.ClientID,
 			ClientSecret: util.ClientSecret,
-			Scopes:       []string{"chat_login", "user_read"},
+			Scopes:       []string{"chat_login", "user_read", "moderator:manage:chat_messages", "whispers:read", "user:manage:whispers"},
 			RedirectURL:  "https://" + util.Publicaddress + "/callback",
 			Endpoint:     twitch.Endpoint,
 		}
 	}
-	// Redirect user to consent page to ask for permission
-	// for the scopes specified above.
-	url := conf.AuthCodeURL(ruser.Mxid, oauth2.AccessTypeOffline)
+	return conf
+}
 
-	if ruser.Room == "" {
-		resp, err := matrix_helper.CreateRoom(util.BotUser.MXClient, "Twitch Bot", "", "", "trusted_private_chat", true)
+// TokenFunc returns a function which returns a valid access token of the Real User.
+// Expired tokens get refreshed and saved.
+func TokenFunc(ruser *user.RealUser) func() (string, error) {
+	return func() (string, error) {
+		token, err := config().TokenSource(context.Background(), ruser.TwitchTokenStruct).Token()
 		if err != nil {
-			return err
+			return "", err
+		}
+		if token.AccessToken != ruser.TwitchTokenStruct.AccessToken {
+			util.AppService.Log.Debugln("Refreshed Twitch token of", ruser.Mxid)
+			ruser.TwitchTokenStruct = token
+			err = util.DB.UpdateRealUserToken(ruser)
+			if err != nil {
+				util.AppService.Log.Errorln(err)
+			}
 		}
-		ruser.Room = resp.RoomID
+		return token.AccessToken, nil
 	}
+}
 
-	joinedResp, err := util.BotUser.MXClient.JoinedMembers(ruser.Room)
-	if err != nil {
-		return err
+// HTTPClient returns a http.Client which authenticates requests as the Real User and refreshes the token if needed
+func HTTPClient(ruser *user.RealUser) *http.Client {
+	if ruser.TwitchHTTPClient == nil {
+		ruser.TwitchHTTPClient = config().Client(context.Background(), ruser.TwitchTokenStruct)
+		ruser.TwitchHTTPClient.Timeout = time.Second * 10
 	}
-	if _, ok := joinedResp.Joined[ruser.Mxid]; !ok {
-		// Workaround gomatrix bug
+	return ruser.TwitchHTTPClient
+}
 
-		inviteReq := &gomatrix.ReqInviteUser{
-			UserID: ruser.Mxid,
-		}
+func SendLoginURL(ruser *user.RealUser) error {
+	// Redirect user to consent page to ask for permission
+	// for the scopes specified above.
+	url := config().AuthCodeURL(ruser.Mxid, oauth2.AccessTypeOffline)
 
-		u := util.BotUser.MXClient.BuildURL("rooms", ruser.Room, "invite")
-		resp := &gomatrix.RespInviteUser{}
-		err = util.BotUser.MXClient.MakeRequest("POST", u, inviteReq, &resp)
-		if err != nil {
-			return err
-		}
+	err := matrix_helper.EnsureBotRoom(ruser)
+	if err != nil {
+		return err
 	}
 
 	_, err = util.BotUser.MXClient.SendNotice(ruser.Room, "Please Login to Twitch using the following URL: "+url+"\n You will get redirected to a Magic Page which you can close as soon as it loaded.")
@@ -92,14 +103,19 @@ func Callback(w http.ResponseWriter, r *http.Request) {
 	code := query.Get("code")
 	state := query.Get("state")
 	if state != "" && code != "" {
-		tok, err := conf.Exchange(ctx, code)
+		tok, err := config().Exchange(ctx, code)
 		if err != nil {
 			util.AppService.Log.Errorln(err)
 			w.WriteHeader(http.StatusInternalServerError)
 		}
-		queryHandler.QueryHandler().RealUsers[state].TwitchTokenStruct = tok
-		queryHandler.QueryHandler().RealUsers[state].TwitchHTTPClient = conf.Client(ctx, tok)
-		queryHandler.QueryHandler().RealUsers[state].TwitchHTTPClient.Timeout = time.Second * 10
+		ruser := user.GetRealUser(queryHandler.QueryHandler().RealUsers, state)
+		if ruser == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ruser.TwitchTokenStruct = tok
+		ruser.TwitchHTTPClient = config().Client(ctx, tok)
+		ruser.TwitchHTTPClient.Timeout = time.Second * 10
 
 		var p profile
 
@@ -126,12 +142,12 @@ func Callback(w http.ResponseWriter, r *http.Request) {
 
 		util.AppService.Log.Debugf("p: %+v\n", p)
 
-		queryHandler.QueryHandler().RealUsers[state].TwitchName = p.Name
+		ruser.TwitchName = p.Name
 		util.AppService.Log.Debugln(p.Name)
 
-		util.DB.SaveUser(queryHandler.QueryHandler().RealUsers[state])
+		util.DB.SaveUser(ruser)
 
-		err = queryHandler.QueryHandler().RealUsers[state].TwitchWS.Connect(tok.AccessToken, p.Name)
+		err = queryHandler.QueryHandler().ConnectRealUser(ruser, TokenFunc(ruser))
 		if err != nil {
 			util.AppService.Log.Errorln(err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -0,0 +1,75 @@
+package implementation
+
+import (
+	"fmt"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/room"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"github.com/matrix-org/gomatrix"
+	"time"
+)
+
+// clearChatLookback limits how far back messages get redacted on a CLEARCHAT
+const clearChatLookback = 24 * time.Hour
+
+// handleClearMsg redacts the Matrix event of a message a moderator deleted on Twitch
+func (w *WebsocketHolder) handleClearMsg(msg *util.TMessage) {
+	message, err := util.DB.GetMessageByTwitchID(msg.Tags["target-msg-id"])
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+		return
+	}
+	if message == nil {
+		util.AppService.Log.Debugln("[TWITCH]: Unknown message got deleted: ", msg.Tags["target-msg-id"])
+		return
+	}
+
+	_, err = util.BotUser.MXClient.RedactEvent(message.RoomID, message.EventID, &gomatrix.ReqRedact{Reason: "Deleted by a moderator on Twitch"})
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+	}
+}
+
+// handleClearChat mirrors timeouts and bans of a user as well as full chat clears to Matrix
+func (w *WebsocketHolder) handleClearChat(msg *util.TMessage) {
+	roomID := room.GetRoomID(w.TwitchRooms, msg.Channel)
+	if roomID == "" {
+		return
+	}
+
+	// Without a target user the whole chat got cleared
+	if msg.Message == "" {
+		_, err := util.BotUser.MXClient.SendNotice(roomID, "The chat was cleared by a moderator on Twitch.")
+		if err != nil {
+			util.AppService.Log.Errorln(err)
+		}
+		return
+	}
+
+	var reason string
+	if msg.Tags["ban-duration"] != "" {
+		reason = fmt.Sprintf("%s was timed out for %s seconds on Twitch.", msg.Message, msg.Tags["ban-duration"])
+	} else {
+		reason = fmt.Sprintf("%s was banned on Twitch.", msg.Message)
+	}
+
+	mxid := w.mxidForLogin(msg.Message)
+	if util.ClearChatMode == "notice" || mxid == "" {
+		_, err := util.BotUser.MXClient.SendNotice(roomID, reason)
+		if err != nil {
+			util.AppService.Log.Errorln(err)
+		}
+		return
+	}
+
+	messages, err := util.DB.GetMessagesBySender(roomID, mxid, time.Now().Add(-clearChatLookback))
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+		return
+	}
+	for _, v := range messages {
+		_, err = util.BotUser.MXClient.RedactEvent(v.RoomID, v.EventID, &gomatrix.ReqRedact{Reason: reason})
+		if err != nil {
+			util.AppService.Log.Errorln(err)
+		}
+	}
+}
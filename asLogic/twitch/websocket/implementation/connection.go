@@ -0,0 +1,260 @@
+package implementation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/irc"
+	twitchWS "github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/websocket"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"github.com/gorilla/websocket"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const (
+	// backoffMin is the delay before the first reconnect try
+	backoffMin = time.Second
+	// backoffMax is the longest delay between two reconnect tries
+	backoffMax = 2 * time.Minute
+	// backoffReset is how long a connection needs to stay up for the backoff to start over
+	backoffReset = time.Minute
+	// readTimeout is how long the connection may stay silent. Twitch sends a PING about every 5 minutes
+	readTimeout = 6 * time.Minute
+)
+
+// errReconnect is returned by listen if Twitch asked us to reconnect
+var errReconnect = errors.New("twitch asked to reconnect")
+
+// Connect starts a goroutine which keeps the connection open until Done gets closed.
+// Lost connections get reopened with a jittered exponential backoff and join all channels again.
+// Once ctx is done lost connections aren't reopened anymore. An open connection stays open until Close gets called.
+func (w *WebsocketHolder) Connect(ctx context.Context) error {
+	if w.Token == nil || w.Username == "" {
+		return fmt.Errorf("twitch connection is missing the login")
+	}
+	w.ctx = ctx
+	w.stopped = make(chan struct{})
+	go w.supervise(ctx)
+	return nil
+}
+
+// Close sends the queued messages, parts all channels and closes the Websocket cleanly.
+// It returns once the connection is closed or ctx is done.
+func (w *WebsocketHolder) Close(ctx context.Context) error {
+	w.drain(ctx)
+	for _, channel := range w.Channels() {
+		err := w.Part(channel)
+		if err != nil {
+			util.AppService.Log.Errorln(err)
+		}
+	}
+	w.closeOnce.Do(func() {
+		close(w.Done)
+	})
+
+	if w.stopped == nil {
+		return nil
+	}
+	select {
+	case <-w.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// State returns the current state of the connection
+func (w *WebsocketHolder) State() twitchWS.State {
+	w.stateMux.Lock()
+	defer w.stateMux.Unlock()
+	if w.state == "" {
+		return twitchWS.StateConnecting
+	}
+	return w.state
+}
+
+func (w *WebsocketHolder) setState(state twitchWS.State) {
+	w.stateMux.Lock()
+	changed := w.state != state
+	w.state = state
+	w.stateMux.Unlock()
+	if !changed {
+		return
+	}
+
+	util.AppService.Log.Debugf("Twitch connection of %s is %s\n", w.Username, state)
+	if w.OnStateChange != nil {
+		w.OnStateChange(state)
+	}
+}
+
+// supervise opens the connection and reopens it until Done gets closed or ctx is done
+func (w *WebsocketHolder) supervise(ctx context.Context) {
+	defer close(w.stopped)
+	defer w.setState(twitchWS.StateClosed)
+
+	attempt := 0
+	for {
+		w.setState(twitchWS.StateConnecting)
+		started := time.Now()
+		err := w.dial(ctx)
+		if err == nil {
+			err = w.listen()
+		}
+
+		select {
+		case <-w.Done:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if err == errReconnect {
+			// Twitch asks all connections to reconnect during maintenance. Redial right away
+			// without entering StateReconnecting so the channels stay on their connections
+			util.AppService.Log.Infof("Twitch asked %s to reconnect\n", w.Username)
+			continue
+		}
+
+		if time.Since(started) > backoffReset {
+			attempt = 0
+		}
+		delay := backoff(attempt)
+		attempt++
+		util.AppService.Log.Warnf("Twitch connection of %s lost: %s. Reconnecting in %s\n", w.Username, err, delay)
+		if w.State() == twitchWS.StateConnected {
+			// A single drop gets redialed first. Only a failed try counts as reconnecting
+			w.setState(twitchWS.StateConnecting)
+		} else {
+			w.setState(twitchWS.StateReconnecting)
+		}
+
+		select {
+		case <-w.Done:
+			return
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// backoff returns the delay before the given reconnect try with up to half of it as random jitter
+func backoff(attempt int) time.Duration {
+	delay := backoffMax
+	if attempt < 16 {
+		delay = backoffMin << uint(attempt)
+		if delay > backoffMax {
+			delay = backoffMax
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// dial opens the Websocket, requests the needed Capabilities and does the Login with a fresh token
+func (w *WebsocketHolder) dial(ctx context.Context) error {
+	token, err := w.Token()
+	if err != nil {
+		return err
+	}
+
+	dialer := &websocket.Dialer{
+		NetDial: func(network, addr string) (net.Conn, error) {
+			netDialer := &net.Dialer{
+				KeepAlive: time.Minute * 60,
+			}
+			return netDialer.Dial(network, addr)
+		},
+		HandshakeTimeout: 45 * time.Second,
+	}
+	conn, _, err := dialer.DialContext(ctx, "wss://irc-ws.chat.twitch.tv:443/irc", nil)
+	if err != nil {
+		return err
+	}
+
+	w.wsMux.Lock()
+	w.WS = conn
+	w.wsMux.Unlock()
+
+	// Request needed IRC Capabilities https://dev.twitch.tv/docs/irc/#twitch-specific-irc-capabilities
+	// and Login
+	for _, line := range []string{"CAP REQ :twitch.tv/membership twitch.tv/tags twitch.tv/commands", "PASS oauth:" + token, "NICK " + w.Username} {
+		err = w.writeLine(line)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// listen reads from the Websocket until it fails or Done gets closed and relays all lines
+func (w *WebsocketHolder) listen() error {
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-w.Done:
+			w.close()
+		case <-stopped:
+		}
+	}()
+	defer w.WS.Close()
+
+	for {
+		err := w.WS.SetReadDeadline(time.Now().Add(readTimeout))
+		if err != nil {
+			return err
+		}
+		_, message, err := w.WS.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		util.AppService.Log.Debugf("recv: %s\n", message)
+		for _, line := range irc.Split(string(message)) {
+			parsedMessage := parseMessage(line)
+			if parsedMessage == nil {
+				continue
+			}
+			if parsedMessage.Command == "RECONNECT" {
+				return errReconnect
+			}
+			w.handleMessage(parsedMessage)
+		}
+	}
+}
+
+// close cleanly closes the Websocket by sending a close message
+func (w *WebsocketHolder) close() {
+	w.wsMux.Lock()
+	defer w.wsMux.Unlock()
+	err := w.WS.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	if err != nil {
+		util.AppService.Log.Debugln(err)
+	}
+	w.WS.Close()
+}
+
+// onWelcome marks the connection as connected and joins all channels again once Twitch confirmed the login
+func (w *WebsocketHolder) onWelcome() {
+	w.setState(twitchWS.StateConnected)
+	w.resetJoins()
+}
+
+// writeLine sends a single IRC line
+func (w *WebsocketHolder) writeLine(line string) error {
+	w.wsMux.Lock()
+	defer w.wsMux.Unlock()
+	if w.WS == nil {
+		return fmt.Errorf("twitch connection of %s is not open", w.Username)
+	}
+	err := w.WS.SetWriteDeadline(time.Now().Add(time.Second * 5))
+	if err != nil {
+		return err
+	}
+	return w.WS.WriteMessage(websocket.TextMessage, []byte(line+"\r\n"))
+}
@@ -0,0 +1,239 @@
+package implementation
+
+import (
+	"fmt"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/matrix_helper"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/room"
+	twitchWS "github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/websocket"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/user"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Twitch allows an account to join 20 channels per 10 seconds.
+// https://dev.twitch.tv/docs/irc/#rate-limits
+const (
+	joinWindow = 10 * time.Second
+	joinLimit  = 20
+)
+
+// joinBatchSize is how many channels get joined with a single comma separated JOIN
+const joinBatchSize = 10
+
+// joinTimeout is how long to wait for the JOIN echo or ROOMSTATE before joining again.
+// It doubles with every try.
+const joinTimeout = 15 * time.Second
+
+// joinRetries is how many JOINs get sent for a channel before giving up on it
+const joinRetries = 5
+
+// joinFailures are the msg-ids of the NOTICEs Twitch sends if a channel can't be joined
+var joinFailures = map[string]bool{
+	"msg_channel_suspended": true,
+	"tos_ban":               true,
+}
+
+// joinTick is how often the scheduler checks for channels to join if nothing else wakes it up
+const joinTick = time.Second
+
+// joinLimiter holds the JOIN rate limit of an account. All connections of the Bot share one.
+type joinLimiter struct {
+	mux    sync.Mutex
+	bucket *tokenBucket
+}
+
+// take returns how many of the wanted joins may be sent now
+func (l *joinLimiter) take(wanted int) int {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.bucket == nil {
+		l.bucket = newTokenBucket(joinLimit, joinWindow)
+	}
+	l.bucket.refill(time.Now())
+	granted := int(l.bucket.tokens)
+	if granted > wanted {
+		granted = wanted
+	}
+	l.bucket.tokens -= float64(granted)
+	return granted
+}
+
+// joinState tracks which channels of a connection are joined
+type joinState struct {
+	mux sync.Mutex
+	// joined holds the channels Twitch confirmed
+	joined map[string]bool
+	// sent holds when the JOIN of an unconfirmed channel got sent
+	sent map[string]time.Time
+	// tries holds how many JOINs got sent for an unconfirmed channel
+	tries map[string]int
+	// signal wakes up the scheduler
+	signal chan struct{}
+	once   sync.Once
+}
+
+// scheduleJoins wakes up the join scheduler and starts it if needed
+func (w *WebsocketHolder) scheduleJoins() {
+	w.joins.once.Do(func() {
+		w.joins.signal = make(chan struct{}, 1)
+		if w.joinLimiter == nil {
+			w.joinLimiter = &joinLimiter{}
+		}
+		go w.joinLoop()
+	})
+	select {
+	case w.joins.signal <- struct{}{}:
+	default:
+	}
+}
+
+// joinLoop joins all channels which aren't joined yet in batches as fast as the rate limit allows until Done gets closed
+func (w *WebsocketHolder) joinLoop() {
+	for {
+		select {
+		case <-w.Done:
+			return
+		case <-w.joins.signal:
+		case <-time.After(joinTick):
+		}
+		if w.State() != twitchWS.StateConnected {
+			continue
+		}
+
+		due := w.dueJoins()
+		granted := w.joinLimiter.take(len(due))
+		for len(due) > 0 && granted > 0 {
+			size := joinBatchSize
+			if size > granted {
+				size = granted
+			}
+			if size > len(due) {
+				size = len(due)
+			}
+			batch := due[:size]
+			due = due[size:]
+			granted -= size
+
+			err := w.writeLine("JOIN #" + strings.Join(batch, ",#"))
+			if err != nil {
+				util.AppService.Log.Errorln(err)
+				break
+			}
+			w.joins.mux.Lock()
+			for _, channel := range batch {
+				w.joins.sent[channel] = time.Now()
+				w.joins.tries[channel]++
+			}
+			w.joins.mux.Unlock()
+		}
+	}
+}
+
+// dueJoins returns the channels which aren't joined and have no unconfirmed JOIN younger than its timeout.
+// Channels which didn't get confirmed after joinRetries JOINs are given up.
+func (w *WebsocketHolder) dueJoins() []string {
+	w.joins.mux.Lock()
+	if w.joins.sent == nil {
+		w.joins.sent = make(map[string]time.Time)
+		w.joins.joined = make(map[string]bool)
+		w.joins.tries = make(map[string]int)
+	}
+
+	var due, failed []string
+	for _, channel := range w.Channels() {
+		if w.joins.joined[channel] {
+			continue
+		}
+		if sent, ok := w.joins.sent[channel]; ok {
+			tries := w.joins.tries[channel]
+			if time.Since(sent) < joinTimeout<<uint(tries-1) {
+				continue
+			}
+			if tries >= joinRetries {
+				failed = append(failed, channel)
+				continue
+			}
+			util.AppService.Log.Warnf("Join of #%s by %s wasn't confirmed. Retrying\n", channel, w.Username)
+		}
+		due = append(due, channel)
+	}
+	w.joins.mux.Unlock()
+
+	for _, channel := range failed {
+		w.giveUpJoin(channel, fmt.Sprintf("Twitch didn't confirm %d joins", joinRetries))
+	}
+	return due
+}
+
+// handleJoinFailure gives up the channel if the NOTICE tells that it can't be joined. It returns false for all other NOTICEs
+func (w *WebsocketHolder) handleJoinFailure(msg *util.TMessage) bool {
+	if !joinFailures[msg.Tags["msg-id"]] || !w.hasChannel(msg.Channel) {
+		return false
+	}
+	w.giveUpJoin(msg.Channel, msg.Message)
+	return true
+}
+
+// giveUpJoin stops joining the channel and reports it in the portal room or to the owner of a puppet connection
+func (w *WebsocketHolder) giveUpJoin(channel, reason string) {
+	w.channelsMux.Lock()
+	delete(w.channels, channel)
+	w.channelsMux.Unlock()
+	w.forgetJoin(channel)
+	util.AppService.Log.Errorf("Gave up joining #%s by %s: %s\n", channel, w.Username, reason)
+
+	notice := fmt.Sprintf("Couldn't join #%s on Twitch: %s", channel, reason)
+	roomID := room.GetRoomID(w.TwitchRooms, channel)
+	if w.Owner != "" {
+		ruser := user.GetRealUser(w.RealUsers, w.Owner)
+		if ruser == nil {
+			return
+		}
+		err := matrix_helper.EnsureBotRoom(ruser)
+		if err != nil {
+			util.AppService.Log.Errorln(err)
+			return
+		}
+		roomID = ruser.Room
+	}
+	if roomID == "" {
+		return
+	}
+	_, err := util.BotUser.MXClient.SendNotice(roomID, notice)
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+	}
+}
+
+// confirmJoin marks the channel as joined after Twitch sent the JOIN echo or a ROOMSTATE
+func (w *WebsocketHolder) confirmJoin(channel string) {
+	w.joins.mux.Lock()
+	defer w.joins.mux.Unlock()
+	if w.joins.joined == nil {
+		return
+	}
+	w.joins.joined[channel] = true
+	delete(w.joins.sent, channel)
+	delete(w.joins.tries, channel)
+}
+
+// forgetJoin marks the channel as not joined
+func (w *WebsocketHolder) forgetJoin(channel string) {
+	w.joins.mux.Lock()
+	defer w.joins.mux.Unlock()
+	delete(w.joins.joined, channel)
+	delete(w.joins.sent, channel)
+	delete(w.joins.tries, channel)
+}
+
+// resetJoins forgets all joins after a reconnect so every channel gets joined again
+func (w *WebsocketHolder) resetJoins() {
+	w.joins.mux.Lock()
+	w.joins.joined = make(map[string]bool)
+	w.joins.sent = make(map[string]time.Time)
+	w.joins.tries = make(map[string]int)
+	w.joins.mux.Unlock()
+	w.scheduleJoins()
+}
@@ -0,0 +1,183 @@
+package implementation
+
+import (
+	"context"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/room"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/user"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"strings"
+	"sync"
+	"time"
+)
+
+// membershipFlushInterval is how long JOIN and PART changes get collected before they are applied to Matrix
+const membershipFlushInterval = 10 * time.Second
+
+// membershipDelay is the pause between two membership changes in Matrix to not flood the homeserver
+const membershipDelay = 200 * time.Millisecond
+
+// roomMembership holds the pending membership changes of one portal room
+type roomMembership struct {
+	w *WebsocketHolder
+	// changes holds the latest wanted membership per Twitch login. true means joined
+	changes map[string]bool
+	// names holds the complete member list if a NAMES reply got received. Ghosts not in it get removed
+	names map[string]bool
+}
+
+// membershipMirror applies the Twitch chat members to the ghosts in the portal rooms if util.MirrorMembership is set
+type membershipMirror struct {
+	mux     sync.Mutex
+	pending map[string]*roomMembership
+	once    sync.Once
+}
+
+var membership = &membershipMirror{
+	pending: make(map[string]*roomMembership),
+}
+
+// handleMembership queues the JOIN or PART of a Twitch user
+func (w *WebsocketHolder) handleMembership(msg *util.TMessage) {
+	// Puppet connections see the same members as the Bot
+	if !util.MirrorMembership || w.Owner != "" {
+		return
+	}
+	roomID := room.GetRoomID(w.TwitchRooms, msg.Channel)
+	if roomID == "" || msg.Username == "" {
+		return
+	}
+	membership.queue(w, roomID, func(pending *roomMembership) {
+		pending.changes[msg.Username] = msg.Command == "JOIN"
+	})
+}
+
+// handleNames collects the logins of a NAMES reply (353) and queues them once the list ends (366)
+func (w *WebsocketHolder) handleNames(msg *util.TMessage) {
+	if !util.MirrorMembership || w.Owner != "" {
+		return
+	}
+	// 353 is "<nick> = #<channel> :<names>" and 366 is "<nick> #<channel> :End of /NAMES list"
+	var channel string
+	for _, param := range msg.Params {
+		if strings.HasPrefix(param, "#") {
+			channel = strings.TrimPrefix(param, "#")
+			break
+		}
+	}
+	roomID := room.GetRoomID(w.TwitchRooms, channel)
+	if roomID == "" {
+		return
+	}
+
+	if msg.Command == "366" {
+		names := w.names[roomID]
+		delete(w.names, roomID)
+		membership.queue(w, roomID, func(pending *roomMembership) {
+			for login := range names {
+				pending.changes[login] = true
+			}
+			pending.names = names
+		})
+		return
+	}
+	if w.names == nil {
+		w.names = make(map[string]map[string]bool)
+	}
+	if w.names[roomID] == nil {
+		w.names[roomID] = make(map[string]bool)
+	}
+	for _, login := range strings.Fields(msg.Message) {
+		w.names[roomID][login] = true
+	}
+}
+
+// queue calls update with the pending changes of the room and starts the worker if needed
+func (m *membershipMirror) queue(w *WebsocketHolder, roomID string, update func(pending *roomMembership)) {
+	m.once.Do(func() {
+		go m.run(w.ctx)
+	})
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.pending[roomID] == nil {
+		m.pending[roomID] = &roomMembership{w: w, changes: make(map[string]bool)}
+	}
+	update(m.pending[roomID])
+}
+
+// run applies the collected changes every membershipFlushInterval until ctx is done
+func (m *membershipMirror) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(membershipFlushInterval):
+		}
+		m.mux.Lock()
+		pending := m.pending
+		m.pending = make(map[string]*roomMembership)
+		m.mux.Unlock()
+
+		for roomID, v := range pending {
+			m.apply(ctx, roomID, v)
+		}
+	}
+}
+
+// apply lets the ghosts join or leave the room so it matches the Twitch chat
+func (m *membershipMirror) apply(ctx context.Context, roomID string, pending *roomMembership) {
+	w := pending.w
+	joinedResp, err := util.BotUser.MXClient.JoinedMembers(roomID)
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+		return
+	}
+
+	if pending.names != nil {
+		for mxid := range joinedResp.Joined {
+			asUser := user.GetASUser(w.Users, mxid)
+			if asUser != nil && !pending.names[asUser.TwitchName] {
+				if _, ok := pending.changes[asUser.TwitchName]; !ok {
+					pending.changes[asUser.TwitchName] = false
+				}
+			}
+		}
+	}
+
+	for login, joined := range pending.changes {
+		if login == util.BotUser.TwitchName || w.isRealUser(login) {
+			continue
+		}
+		if !joined {
+			asUser := user.GetASUser(w.TwitchUsers, login)
+			if asUser == nil {
+				continue
+			}
+			if _, ok := joinedResp.Joined[asUser.Mxid]; !ok {
+				continue
+			}
+			_, err = asUser.MXClient.LeaveRoom(roomID)
+		} else {
+			var asUser *user.ASUser
+			asUser, err = w.getASUser(login, "")
+			if err != nil {
+				util.AppService.Log.Errorln(err)
+				continue
+			}
+			if asUser == nil {
+				continue
+			}
+			if _, ok := joinedResp.Joined[asUser.Mxid]; ok {
+				continue
+			}
+			_, err = asUser.MXClient.JoinRoom(roomID, "", nil)
+		}
+		if err != nil {
+			util.AppService.Log.Errorln(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(membershipDelay):
+		}
+	}
+}
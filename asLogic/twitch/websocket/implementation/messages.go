@@ -0,0 +1,108 @@
+package implementation
+
+import (
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/room"
+	twitchWS "github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/websocket"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/user"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"time"
+)
+
+// pendingTimeout is how long a sent message waits for Twitch to confirm it with its id
+const pendingTimeout = 10 * time.Second
+
+// pendingMessage is a message sent to Twitch which didn't get its Twitch id yet
+type pendingMessage struct {
+	message *twitchWS.OutgoingMessage
+	sent    time.Time
+}
+
+// addPending registers a message which is about to be sent so the USERSTATE confirming it can be matched
+func (w *WebsocketHolder) addPending(message *twitchWS.OutgoingMessage) *pendingMessage {
+	w.pendingMux.Lock()
+	defer w.pendingMux.Unlock()
+	if w.pending == nil {
+		w.pending = make(map[string][]*pendingMessage)
+	}
+	pending := &pendingMessage{
+		message: message,
+		sent:    time.Now(),
+	}
+	w.pending[message.Channel] = append(w.pending[message.Channel], pending)
+	return pending
+}
+
+// removePending drops a registered message again if sending it failed
+func (w *WebsocketHolder) removePending(pending *pendingMessage) {
+	w.pendingMux.Lock()
+	defer w.pendingMux.Unlock()
+	channel := pending.message.Channel
+	for i, v := range w.pending[channel] {
+		if v == pending {
+			w.pending[channel] = append(w.pending[channel][:i:i], w.pending[channel][i+1:]...)
+			return
+		}
+	}
+}
+
+// popPending returns the oldest message sent to the channel which still waits for its confirmation
+func (w *WebsocketHolder) popPending(channel string) *twitchWS.OutgoingMessage {
+	w.pendingMux.Lock()
+	defer w.pendingMux.Unlock()
+	for len(w.pending[channel]) > 0 {
+		next := w.pending[channel][0]
+		w.pending[channel] = w.pending[channel][1:]
+		if time.Since(next.sent) <= pendingTimeout {
+			return next.message
+		}
+	}
+	return nil
+}
+
+// handleUserState maps the id Twitch assigned to our last sent message to its Matrix event.
+// On puppet connections the badges of the Real User are applied to the portal room.
+func (w *WebsocketHolder) handleUserState(msg *util.TMessage) {
+	if state := w.twitchState(); state != nil {
+		state.SetChannel(msg.Channel, msg.Tags)
+	}
+	if w.Owner != "" {
+		powerLevels.set(room.GetRoomID(w.TwitchRooms, msg.Channel), w.Owner, levelForBadges(msg.Tags["badges"]))
+	}
+
+	if msg.Tags["id"] == "" {
+		// USERSTATE without id is sent when joining a channel
+		return
+	}
+	message := w.popPending(msg.Channel)
+	if message == nil || message.EventID == "" {
+		return
+	}
+	err := util.DB.SaveMessage(&room.Message{
+		TwitchID:  msg.Tags["id"],
+		EventID:   message.EventID,
+		RoomID:    message.RoomID,
+		Sender:    message.Sender,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+	}
+}
+
+// handleGlobalUserState stores the global badges, color and emote sets of the account sent after the login
+func (w *WebsocketHolder) handleGlobalUserState(msg *util.TMessage) {
+	if state := w.twitchState(); state != nil {
+		state.SetGlobal(msg.Tags)
+	}
+}
+
+// twitchState returns the state of the account the connection is logged in with
+func (w *WebsocketHolder) twitchState() *user.TwitchState {
+	if w.Owner == "" {
+		return &util.BotUser.TwitchState
+	}
+	if ruser := user.GetRealUser(w.RealUsers, w.Owner); ruser != nil {
+		return &ruser.TwitchState
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package implementation
+
+import (
+	twitchWS "github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/websocket"
+	"testing"
+)
+
+func TestRemovePending(t *testing.T) {
+	w := &WebsocketHolder{}
+	first := &twitchWS.OutgoingMessage{Channel: "mtrnord", Text: "first"}
+	failed := &twitchWS.OutgoingMessage{Channel: "mtrnord", Text: "failed"}
+	last := &twitchWS.OutgoingMessage{Channel: "mtrnord", Text: "last"}
+
+	w.addPending(first)
+	w.removePending(w.addPending(failed))
+	w.addPending(last)
+
+	for _, want := range []*twitchWS.OutgoingMessage{first, last} {
+		if got := w.popPending("mtrnord"); got != want {
+			t.Errorf("popPending = %+v, want %+v", got, want)
+		}
+	}
+	if got := w.popPending("mtrnord"); got != nil {
+		t.Errorf("popPending = %+v, want nil", got)
+	}
+}
@@ -0,0 +1,41 @@
+package implementation
+
+import (
+	"fmt"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/matrix_helper"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/user"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"strings"
+)
+
+// handleNotice tells the owner of a puppet connection about NOTICEs Twitch sent them.
+// NOTICEs with a msg-id starting with "msg_" mean that Twitch rejected the last message sent to the channel.
+func (w *WebsocketHolder) handleNotice(msg *util.TMessage) {
+	if w.Owner == "" {
+		util.AppService.Log.Debugf("[TWITCH]: %+v\n", msg)
+		return
+	}
+	ruser := user.GetRealUser(w.RealUsers, w.Owner)
+	if ruser == nil {
+		return
+	}
+
+	text := "Twitch: " + msg.Message
+	if strings.HasPrefix(msg.Tags["msg-id"], "msg_") {
+		text = fmt.Sprintf("Your message to #%s was not delivered: %s", msg.Channel, msg.Message)
+		failed := w.popPending(msg.Channel)
+		if failed != nil && failed.EventID != "" {
+			text += fmt.Sprintf("\nhttps://matrix.to/#/%s/%s", failed.RoomID, failed.EventID)
+		}
+	}
+
+	err := matrix_helper.EnsureBotRoom(ruser)
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+		return
+	}
+	_, err = util.BotUser.MXClient.SendNotice(ruser.Room, text)
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+	}
+}
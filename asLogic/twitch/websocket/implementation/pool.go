@@ -0,0 +1,159 @@
+package implementation
+
+import (
+	"context"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/room"
+	twitchWS "github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/websocket"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/user"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"sync"
+)
+
+// Pool spreads the channels the Bot listens to over as few connections as possible.
+// Every connection joins up to util.ChannelsPerConnection channels.
+type Pool struct {
+	Users       map[string]*user.ASUser
+	RealUsers   map[string]*user.RealUser
+	TwitchUsers map[string]*user.ASUser
+	TwitchRooms map[string]string
+	Aliases     map[string]*room.Room
+	// Context stops reconnecting the connections once it is done
+	Context context.Context
+
+	mux   sync.Mutex
+	conns []*WebsocketHolder
+	// channels holds the connection per joined channel
+	channels map[string]*WebsocketHolder
+	// joins holds the JOIN rate limit of the Bot account which all connections share
+	joins joinLimiter
+}
+
+// Join joins the channel on a connection with space left and opens a new connection if all are full
+func (p *Pool) Join(channel string) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.channels == nil {
+		p.channels = make(map[string]*WebsocketHolder)
+	}
+	// Channels the connection gave up on get joined again
+	if conn := p.channels[channel]; conn != nil && conn.hasChannel(channel) {
+		return nil
+	}
+
+	conn := p.freeConn(nil)
+	if conn == nil {
+		util.AppService.Log.Debugln("Opening new Bot connection")
+		conn = &WebsocketHolder{
+			Done:        make(chan struct{}),
+			TwitchRooms: p.TwitchRooms,
+			Aliases:     p.Aliases,
+			TwitchUsers: p.TwitchUsers,
+			RealUsers:   p.RealUsers,
+			Users:       p.Users,
+			Username:    util.BotUser.TwitchName,
+			joinLimiter: &p.joins,
+			Token: func() (string, error) {
+				return util.BotUser.TwitchToken, nil
+			},
+		}
+		conn.OnStateChange = func(state twitchWS.State) {
+			// Only failed redials move the channels. RECONNECT requests and single drops don't
+			if state == twitchWS.StateReconnecting {
+				go p.rebalance(conn)
+			}
+		}
+		err := conn.Connect(p.Context)
+		if err != nil {
+			return err
+		}
+		p.conns = append(p.conns, conn)
+	}
+
+	err := conn.Join(channel)
+	if err != nil {
+		return err
+	}
+	p.channels[channel] = conn
+	return nil
+}
+
+// freeConn returns a connection which has space for another channel or nil if all are full.
+// If except is set it gets skipped together with all connections which aren't connected.
+func (p *Pool) freeConn(except *WebsocketHolder) *WebsocketHolder {
+	for _, conn := range p.conns {
+		if conn == except || (except != nil && conn.State() != twitchWS.StateConnected) {
+			continue
+		}
+		if util.ChannelsPerConnection <= 0 || len(conn.Channels()) < util.ChannelsPerConnection {
+			return conn
+		}
+	}
+	return nil
+}
+
+// rebalance moves the channels of a dropped connection to connected connections with space left.
+// Channels which don't fit stay on the dropped connection and get joined again once it reconnected.
+// A dropped connection without channels left gets closed.
+func (p *Pool) rebalance(dropped *WebsocketHolder) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	moved := 0
+	for _, channel := range dropped.Channels() {
+		conn := p.freeConn(dropped)
+		if conn == nil {
+			break
+		}
+		err := dropped.Part(channel)
+		if err != nil {
+			util.AppService.Log.Errorln(err)
+		}
+		err = conn.Join(channel)
+		if err != nil {
+			util.AppService.Log.Errorln(err)
+			continue
+		}
+		p.channels[channel] = conn
+		moved++
+	}
+	if moved > 0 {
+		util.AppService.Log.Warnf("Moved %d channels of a dropped Bot connection\n", moved)
+	}
+	if len(dropped.Channels()) > 0 {
+		return
+	}
+
+	for i, conn := range p.conns {
+		if conn == dropped {
+			p.conns = append(p.conns[:i:i], p.conns[i+1:]...)
+			util.AppService.Log.Debugln("Closing empty Bot connection")
+			go func() {
+				err := dropped.Close(context.Background())
+				if err != nil {
+					util.AppService.Log.Errorln(err)
+				}
+			}()
+			return
+		}
+	}
+}
+
+// Close closes all connections at once and returns once all are closed or ctx is done
+func (p *Pool) Close(ctx context.Context) {
+	p.mux.Lock()
+	conns := p.conns
+	p.mux.Unlock()
+
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn *WebsocketHolder) {
+			defer wg.Done()
+			err := conn.Close(ctx)
+			if err != nil {
+				util.AppService.Log.Errorln(err)
+			}
+		}(conn)
+	}
+	wg.Wait()
+}
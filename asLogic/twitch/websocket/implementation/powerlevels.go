@@ -0,0 +1,190 @@
+package implementation
+
+import (
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/user"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"sync"
+	"time"
+)
+
+// badgeLevels maps Twitch badges to the power level they grant in the portal room.
+// Levels get capped below the level of the Bot as it couldn't lower them again otherwise.
+var badgeLevels = map[string]int{
+	"broadcaster": 100,
+	"moderator":   50,
+	"vip":         10,
+}
+
+// powerLevelDebounce is how long badge changes get collected before the power levels of a room get updated
+const powerLevelDebounce = 5 * time.Second
+
+// powerLevelUpdater keeps the power levels of portal rooms in sync with the Twitch badges
+type powerLevelUpdater struct {
+	mux sync.Mutex
+	// levels holds the last known level per MXID per room. It gets seeded from the room on first use
+	levels map[string]map[string]int
+	// botLevels holds the level of the Bot per room
+	botLevels map[string]int
+	// pending holds the changed levels per room which didn't get sent yet
+	pending map[string]map[string]int
+	timers  map[string]*time.Timer
+}
+
+var powerLevels = &powerLevelUpdater{
+	levels:    make(map[string]map[string]int),
+	botLevels: make(map[string]int),
+	pending:   make(map[string]map[string]int),
+	timers:    make(map[string]*time.Timer),
+}
+
+// levelForBadges returns the highest power level the badges tag like "broadcaster/1,subscriber/12" grants
+func levelForBadges(badges string) int {
+	level := 0
+	for name := range user.ParseBadges(badges) {
+		if badgeLevels[name] > level {
+			level = badgeLevels[name]
+		}
+	}
+	return level
+}
+
+// set schedules an update of the power level of the user in the room if it changed
+func (p *powerLevelUpdater) set(roomID, mxid string, level int) {
+	if roomID == "" || mxid == "" || mxid == util.BotUser.Mxid {
+		return
+	}
+	p.mux.Lock()
+	seeded := p.levels[roomID] != nil
+	p.mux.Unlock()
+	if !seeded {
+		p.seed(roomID)
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.levels[roomID] == nil {
+		p.levels[roomID] = make(map[string]int)
+	}
+	if botLevel, ok := p.botLevels[roomID]; ok {
+		level = capLevel(level, botLevel)
+	}
+	current, known := p.levels[roomID][mxid]
+	p.levels[roomID][mxid] = level
+	// Users without badges we never gave a level keep whatever they have in Matrix
+	if current == level && (known || level == 0) {
+		return
+	}
+
+	if p.pending[roomID] == nil {
+		p.pending[roomID] = make(map[string]int)
+	}
+	p.pending[roomID][mxid] = level
+	if p.timers[roomID] == nil {
+		p.timers[roomID] = time.AfterFunc(powerLevelDebounce, func() {
+			p.flush(roomID)
+		})
+	}
+}
+
+// seed loads the levels we could have granted and the level of the Bot from the room.
+// It does nothing if the power levels can't be fetched so the next set tries again.
+func (p *powerLevelUpdater) seed(roomID string) {
+	content := make(map[string]interface{})
+	err := util.BotUser.MXClient.StateEvent(roomID, "m.room.power_levels", "", &content)
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+		return
+	}
+	users, botLevel := userLevels(content)
+
+	// Levels we don't grant were set by hand and stay untouched unless the user gets a badge
+	granted := make(map[int]bool)
+	for _, level := range badgeLevels {
+		granted[capLevel(level, botLevel)] = true
+	}
+	levels := make(map[string]int)
+	for mxid, level := range users {
+		if granted[level] {
+			levels[mxid] = level
+		}
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.levels[roomID] == nil {
+		p.levels[roomID] = levels
+		p.botLevels[roomID] = botLevel
+	}
+}
+
+// userLevels returns the levels of the users field and the level of the Bot of m.room.power_levels content
+func userLevels(content map[string]interface{}) (users map[string]int, botLevel int) {
+	users = make(map[string]int)
+	if raw, ok := content["users"].(map[string]interface{}); ok {
+		for mxid, level := range raw {
+			if number, ok := level.(float64); ok {
+				users[mxid] = int(number)
+			}
+		}
+	}
+	botLevel, ok := users[util.BotUser.Mxid]
+	if !ok {
+		if number, ok := content["users_default"].(float64); ok {
+			botLevel = int(number)
+		}
+	}
+	return users, botLevel
+}
+
+// capLevel returns the level lowered to one below the level of the Bot if needed
+func capLevel(level, botLevel int) int {
+	if level >= botLevel {
+		return botLevel - 1
+	}
+	return level
+}
+
+// flush sends all pending level changes of the room in a single m.room.power_levels event
+func (p *powerLevelUpdater) flush(roomID string) {
+	p.mux.Lock()
+	changes := p.pending[roomID]
+	delete(p.pending, roomID)
+	delete(p.timers, roomID)
+	p.mux.Unlock()
+
+	if len(changes) == 0 {
+		return
+	}
+
+	// Use a generic map to keep fields we don't know about
+	content := make(map[string]interface{})
+	err := util.BotUser.MXClient.StateEvent(roomID, "m.room.power_levels", "", &content)
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+		return
+	}
+	users, ok := content["users"].(map[string]interface{})
+	if !ok {
+		users = make(map[string]interface{})
+	}
+	current, botLevel := userLevels(content)
+	for mxid, level := range changes {
+		if current[mxid] >= botLevel {
+			// Matrix doesn't allow to change users with the same or a higher level than the Bot
+			continue
+		}
+		level = capLevel(level, botLevel)
+		if level <= 0 {
+			delete(users, mxid)
+		} else {
+			users[mxid] = level
+		}
+	}
+	content["users"] = users
+
+	_, err = util.BotUser.MXClient.SendStateEvent(roomID, "m.room.power_levels", "", content)
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+	}
+}
@@ -0,0 +1,36 @@
+package implementation
+
+import "testing"
+
+func TestLevelForBadges(t *testing.T) {
+	tests := map[string]int{
+		"":                          0,
+		"subscriber/12":             0,
+		"vip/1,subscriber/12":       10,
+		"moderator/1,subscriber/12": 50,
+		"broadcaster/1,moderator/1": 100,
+	}
+	for badges, want := range tests {
+		if got := levelForBadges(badges); got != want {
+			t.Errorf("levelForBadges(%q) = %d, want %d", badges, got, want)
+		}
+	}
+}
+
+func TestCapLevel(t *testing.T) {
+	tests := []struct {
+		level    int
+		botLevel int
+		want     int
+	}{
+		{100, 100, 99},
+		{50, 100, 50},
+		{50, 50, 49},
+		{0, 100, 0},
+	}
+	for _, tt := range tests {
+		if got := capLevel(tt.level, tt.botLevel); got != tt.want {
+			t.Errorf("capLevel(%d, %d) = %d, want %d", tt.level, tt.botLevel, got, tt.want)
+		}
+	}
+}
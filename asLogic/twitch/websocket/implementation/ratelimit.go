@@ -0,0 +1,218 @@
+package implementation
+
+import (
+	"context"
+	twitchWS "github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/websocket"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"sync"
+	"time"
+)
+
+// Twitch allows 20 messages per 30 seconds and 100 per 30 seconds in channels the sender moderates.
+// https://dev.twitch.tv/docs/irc/#rate-limits
+const (
+	rateWindow   = 30 * time.Second
+	rateLimit    = 20
+	rateLimitMod = 100
+)
+
+// sendQueueSize is how many messages may wait per connection before SendMessage refuses new ones
+const sendQueueSize = 50
+
+// sendRetryDelay is how long the queue waits before trying again while the connection is down
+const sendRetryDelay = time.Second
+
+// drainTick is how often drain checks if the queue is empty
+const drainTick = 100 * time.Millisecond
+
+// userStateTick is how often the queue checks if the USERSTATE of a joined channel arrived
+const userStateTick = 100 * time.Millisecond
+
+// tokenBucket allows capacity actions at once and refills them evenly over window
+type tokenBucket struct {
+	capacity float64
+	window   time.Duration
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(capacity int, window time.Duration) *tokenBucket {
+	return &tokenBucket{
+		capacity: float64(capacity),
+		window:   window,
+		tokens:   float64(capacity),
+		last:     time.Now(),
+	}
+}
+
+// refill adds the tokens which got refilled since the last call
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.capacity / b.window.Seconds()
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// wait returns how long it takes until a token is available
+func (b *tokenBucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * b.window.Seconds() / b.capacity * float64(time.Second))
+}
+
+// rateLimiter holds the buckets of a single Twitch account.
+// Messages to channels the account moderates only count against the mod bucket,
+// all other messages count against both.
+type rateLimiter struct {
+	normal *tokenBucket
+	mod    *tokenBucket
+}
+
+// reserve takes a token for a message and returns 0 or returns how long to wait if there is none
+func (r *rateLimiter) reserve(moderator bool) time.Duration {
+	if r.normal == nil {
+		r.normal = newTokenBucket(rateLimit, rateWindow)
+		r.mod = newTokenBucket(rateLimitMod, rateWindow)
+	}
+	now := time.Now()
+	r.normal.refill(now)
+	r.mod.refill(now)
+
+	wait := r.mod.wait()
+	if !moderator && r.normal.wait() > wait {
+		wait = r.normal.wait()
+	}
+	if wait > 0 {
+		return wait
+	}
+	r.mod.tokens--
+	if !moderator {
+		r.normal.tokens--
+	}
+	return 0
+}
+
+// sendQueue holds the messages of a connection which wait for the rate limit or the connection
+type sendQueue struct {
+	mux      sync.Mutex
+	messages []*twitchWS.OutgoingMessage
+	// signal gets a value whenever a message got queued
+	signal chan struct{}
+	once   sync.Once
+}
+
+// SendMessage queues messages on behalf of a Matrix event.
+// Either all messages get queued or none and twitchWS.ErrQueueFull is returned if they don't fit into the queue.
+// The event gets mapped to the Twitch message id as soon as Twitch confirms the message with a USERSTATE.
+func (w *WebsocketHolder) SendMessage(messages ...*twitchWS.OutgoingMessage) error {
+	w.queue.once.Do(func() {
+		w.queue.signal = make(chan struct{}, 1)
+		go w.sendLoop()
+	})
+
+	w.queue.mux.Lock()
+	if len(w.queue.messages)+len(messages) > sendQueueSize {
+		w.queue.mux.Unlock()
+		return twitchWS.ErrQueueFull
+	}
+	w.queue.messages = append(w.queue.messages, messages...)
+	w.queue.mux.Unlock()
+
+	select {
+	case w.queue.signal <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// sendLoop sends the queued messages as fast as the rate limit allows until Done gets closed
+func (w *WebsocketHolder) sendLoop() {
+	limiter := &rateLimiter{}
+	// waitingSince is when the first queued message started to wait for the USERSTATE of its channel
+	var waiting *twitchWS.OutgoingMessage
+	var waitingSince time.Time
+	for {
+		w.queue.mux.Lock()
+		var message *twitchWS.OutgoingMessage
+		if len(w.queue.messages) > 0 {
+			message = w.queue.messages[0]
+		}
+		w.queue.mux.Unlock()
+
+		if message == nil {
+			select {
+			case <-w.Done:
+				return
+			case <-w.queue.signal:
+			}
+			continue
+		}
+
+		if waiting != message {
+			waiting = message
+			waitingSince = time.Now()
+		}
+
+		wait := sendRetryDelay
+		if w.State() == twitchWS.StateConnected {
+			state := w.twitchState()
+			// Twitch sends the USERSTATE with the moderator status on JOIN. Until then it's unknown which limit applies
+			if state != nil && state.Channel(message.Channel) == nil && w.hasChannel(message.Channel) && time.Since(waitingSince) < joinTimeout {
+				wait = userStateTick
+			} else {
+				wait = limiter.reserve(state != nil && state.IsModerator(message.Channel))
+			}
+		}
+		if wait > 0 {
+			select {
+			case <-w.Done:
+				return
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		// Twitch may confirm the message before the write returns, so it has to be pending already
+		pending := w.addPending(message)
+		err := w.sendPrivmsg(message.Tags, message.Channel, message.Text)
+		if err != nil {
+			// The message stays queued and gets sent once the connection is back
+			w.removePending(pending)
+			util.AppService.Log.Errorln(err)
+			select {
+			case <-w.Done:
+				return
+			case <-time.After(sendRetryDelay):
+			}
+			continue
+		}
+
+		w.queue.mux.Lock()
+		w.queue.messages = w.queue.messages[1:]
+		w.queue.mux.Unlock()
+	}
+}
+
+// drain waits until all queued messages are sent, the connection is down or ctx is done
+func (w *WebsocketHolder) drain(ctx context.Context) {
+	for {
+		w.queue.mux.Lock()
+		left := len(w.queue.messages)
+		w.queue.mux.Unlock()
+		if left == 0 || w.State() != twitchWS.StateConnected {
+			if left > 0 {
+				util.AppService.Log.Warnf("Dropping %d queued messages of %s as the connection is down\n", left, w.Username)
+			}
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			util.AppService.Log.Warnf("Dropping %d queued messages of %s\n", left, w.Username)
+			return
+		case <-time.After(drainTick):
+		}
+	}
+}
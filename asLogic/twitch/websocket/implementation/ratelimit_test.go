@@ -0,0 +1,47 @@
+package implementation
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(20, 30*time.Second)
+	start := bucket.last
+
+	for i := 0; i < 20; i++ {
+		if wait := bucket.wait(); wait != 0 {
+			t.Fatalf("token %d: wait = %s, want 0", i, wait)
+		}
+		bucket.tokens--
+	}
+	if wait := bucket.wait(); wait != 1500*time.Millisecond {
+		t.Errorf("empty bucket: wait = %s, want 1.5s", wait)
+	}
+
+	bucket.refill(start.Add(3 * time.Second))
+	if bucket.tokens != 2 {
+		t.Errorf("after 3s: tokens = %v, want 2", bucket.tokens)
+	}
+
+	bucket.refill(start.Add(time.Hour))
+	if bucket.tokens != 20 {
+		t.Errorf("after an hour: tokens = %v, want capacity 20", bucket.tokens)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := &rateLimiter{}
+	for i := 0; i < rateLimit; i++ {
+		if wait := limiter.reserve(false); wait != 0 {
+			t.Fatalf("message %d: wait = %s, want 0", i, wait)
+		}
+	}
+	if wait := limiter.reserve(false); wait <= 0 {
+		t.Errorf("message %d: wait = %s, want the normal limit to apply", rateLimit, wait)
+	}
+	// Channels the sender moderates only count against the higher mod limit
+	if wait := limiter.reserve(true); wait != 0 {
+		t.Errorf("moderated channel: wait = %s, want 0", wait)
+	}
+}
@@ -0,0 +1,169 @@
+package implementation
+
+import (
+	"fmt"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// emoteURL is the Twitch CDN URL of an emote image
+const emoteURL = "https://static-cdn.jtvnw.net/emoticons/v2/%s/default/dark/1.0"
+
+// emoteRange is the position of an emote in a message counted in characters. End is inclusive
+type emoteRange struct {
+	id    string
+	start int
+	end   int
+}
+
+// parseEmotes parses the emotes tag like "25:0-4,12-16/1902:6-10" and returns the ranges sorted by position
+func parseEmotes(tag string) []emoteRange {
+	var ranges []emoteRange
+	for _, emote := range strings.Split(tag, "/") {
+		parts := strings.SplitN(emote, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		for _, position := range strings.Split(parts[1], ",") {
+			bounds := strings.SplitN(position, "-", 2)
+			if len(bounds) != 2 {
+				continue
+			}
+			start, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				continue
+			}
+			end, err := strconv.Atoi(bounds[1])
+			if err != nil {
+				continue
+			}
+			ranges = append(ranges, emoteRange{id: parts[0], start: start, end: end})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start < ranges[j].start
+	})
+	return ranges
+}
+
+// colorRegex matches the color tag. It is empty if the user never picked a color
+var colorRegex = regexp.MustCompile("^#[0-9A-Fa-f]{6}$")
+
+// mentionRegex matches "@login" mentions. Twitch logins only contain letters, digits and underscores
+var mentionRegex = regexp.MustCompile(`(^|[^\w@])@(\w{1,25})`)
+
+// renderMessage returns the formatted_body of a Twitch message with emotes as inline images,
+// mentions of known users as pills and the sender name colored if util.ColorNames is set.
+// It returns an empty string if the message doesn't need formatting.
+func (w *WebsocketHolder) renderMessage(text string, tags map[string]string, isAction bool) string {
+	formatted, changed := w.renderBody(text, tags)
+	if !changed {
+		formatted = ""
+	}
+	color := tags["color"]
+	if !util.ColorNames || !colorRegex.MatchString(color) {
+		return formatted
+	}
+
+	if formatted == "" {
+		formatted = html.EscapeString(text)
+	}
+	font := fmt.Sprintf(`<font color="%s" data-mx-color="%s">`, color, color)
+	// Twitch shows the whole text of actions in the color of the user
+	if isAction {
+		return font + formatted + "</font>"
+	}
+	displayName := tags["display-name"]
+	if displayName == "" {
+		displayName = tags["login"]
+	}
+	if displayName == "" {
+		return formatted
+	}
+	return font + "<b>" + html.EscapeString(displayName) + "</b></font>: " + formatted
+}
+
+// renderBody returns the message as HTML with emotes as inline images and mentions as pills.
+// changed is false if the HTML is just the escaped text.
+func (w *WebsocketHolder) renderBody(text string, tags map[string]string) (formatted string, changed bool) {
+	runes := []rune(text)
+	var b strings.Builder
+	pos := 0
+	for _, v := range parseEmotes(tags["emotes"]) {
+		if v.start < pos || v.end >= len(runes) || v.start > v.end {
+			continue
+		}
+		segment, pills := w.renderMentions(string(runes[pos:v.start]))
+		b.WriteString(segment)
+		changed = changed || pills
+		name := string(runes[v.start : v.end+1])
+		mxc, err := emoteMXC(v.id)
+		if err != nil {
+			util.AppService.Log.Errorln(err)
+		}
+		if mxc == "" {
+			b.WriteString(html.EscapeString(name))
+		} else {
+			name = html.EscapeString(name)
+			b.WriteString(fmt.Sprintf(`<img data-mx-emoticon src="%s" alt="%s" title="%s" height="32" />`, mxc, name, name))
+			changed = true
+		}
+		pos = v.end + 1
+	}
+	segment, pills := w.renderMentions(string(runes[pos:]))
+	b.WriteString(segment)
+	return b.String(), changed || pills
+}
+
+// renderMentions escapes the text and turns mentions of Twitch users known in Matrix into pills
+func (w *WebsocketHolder) renderMentions(text string) (formatted string, pills bool) {
+	var b strings.Builder
+	pos := 0
+	for _, match := range mentionRegex.FindAllStringSubmatchIndex(text, -1) {
+		// match[4]:match[5] is the login, the "@" directly precedes it
+		mxid := w.mxidForLogin(strings.ToLower(text[match[4]:match[5]]))
+		if mxid == "" {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos : match[4]-1]))
+		b.WriteString(fmt.Sprintf(`<a href="https://matrix.to/#/%s">%s</a>`, mxid, html.EscapeString(text[match[4]-1:match[5]])))
+		pos = match[5]
+		pills = true
+	}
+	b.WriteString(html.EscapeString(text[pos:]))
+	return b.String(), pills
+}
+
+// mentionedMXIDs returns the MXIDs of the Twitch users known in Matrix which the text mentions
+func (w *WebsocketHolder) mentionedMXIDs(text string) []string {
+	var mxids []string
+	seen := make(map[string]bool)
+	for _, match := range mentionRegex.FindAllStringSubmatch(text, -1) {
+		mxid := w.mxidForLogin(strings.ToLower(match[2]))
+		if mxid == "" || seen[mxid] {
+			continue
+		}
+		seen[mxid] = true
+		mxids = append(mxids, mxid)
+	}
+	return mxids
+}
+
+// emoteMXC returns the mxc URI of a Twitch emote and uploads it to the homeserver if it isn't known yet
+func emoteMXC(emoteID string) (string, error) {
+	mxc, err := util.DB.GetEmote(emoteID)
+	if err != nil || mxc != "" {
+		return mxc, err
+	}
+
+	resp, err := util.BotUser.MXClient.UploadLink(fmt.Sprintf(emoteURL, emoteID))
+	if err != nil {
+		return "", err
+	}
+	err = util.DB.SaveEmote(emoteID, resp.ContentURI)
+	return resp.ContentURI, err
+}
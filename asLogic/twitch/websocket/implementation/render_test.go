@@ -0,0 +1,44 @@
+package implementation
+
+import (
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/user"
+	"reflect"
+	"testing"
+)
+
+func TestParseEmotes(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want []emoteRange
+	}{
+		{"", nil},
+		{"25:0-4", []emoteRange{{id: "25", start: 0, end: 4}}},
+		{"25:0-4,12-16/1902:6-10", []emoteRange{
+			{id: "25", start: 0, end: 4},
+			{id: "1902", start: 6, end: 10},
+			{id: "25", start: 12, end: 16},
+		}},
+		{"emotesv2_abc:3-7", []emoteRange{{id: "emotesv2_abc", start: 3, end: 7}}},
+		{"25:x-4/1902:6/33", nil},
+	}
+
+	for _, tt := range tests {
+		got := parseEmotes(tt.tag)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseEmotes(%q) = %+v, want %+v", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestMentionedMXIDs(t *testing.T) {
+	w := &WebsocketHolder{
+		TwitchUsers: map[string]*user.ASUser{"ghost": {Mxid: "@twitch_ghost:example.com", TwitchName: "ghost"}},
+		RealUsers:   map[string]*user.RealUser{"@alice:example.com": {Mxid: "@alice:example.com", TwitchName: "alice_tv"}},
+	}
+
+	got := w.mentionedMXIDs("@Ghost hi @alice_tv, @ghost again @unknown mail@ghost")
+	want := []string{"@twitch_ghost:example.com", "@alice:example.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mentionedMXIDs = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,113 @@
+package implementation
+
+import (
+	"fmt"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/room"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"strconv"
+	"strings"
+)
+
+// topicPrefix marks the line of the room topic managed by the bridge
+const topicPrefix = "Twitch chat: "
+
+type topicContent struct {
+	Topic string `json:"topic"`
+}
+
+// handleRoomState stores the chat settings of a ROOMSTATE on the room and exposes them in the portal room
+func (w *WebsocketHolder) handleRoomState(msg *util.TMessage) {
+	troom := w.roomByChannel(msg.Channel)
+	if troom == nil {
+		return
+	}
+	if msg.Tags["room-id"] != "" {
+		troom.TwitchRoomID = msg.Tags["room-id"]
+	}
+	// Twitch only sends the complete state on join. Later updates only contain the changed setting
+	applyRoomState(&troom.State, msg.Tags)
+
+	current := room.State{}
+	err := util.BotUser.MXClient.StateEvent(troom.ID, room.StateEventType, "", &current)
+	if err != nil || current != troom.State {
+		_, err = util.BotUser.MXClient.SendStateEvent(troom.ID, room.StateEventType, "", troom.State)
+		if err != nil {
+			util.AppService.Log.Errorln(err)
+		}
+	}
+
+	if util.RoomStateTopic {
+		err = updateTopic(troom.ID, describeRoomState(troom.State))
+		if err != nil {
+			util.AppService.Log.Errorln(err)
+		}
+	}
+}
+
+func applyRoomState(state *room.State, tags map[string]string) {
+	if v, ok := tags["emote-only"]; ok {
+		state.EmoteOnly = v == "1"
+	}
+	if v, ok := tags["followers-only"]; ok {
+		state.FollowersOnly, _ = strconv.Atoi(v)
+	}
+	if v, ok := tags["r9k"]; ok {
+		state.R9K = v == "1"
+	}
+	if v, ok := tags["slow"]; ok {
+		state.Slow, _ = strconv.Atoi(v)
+	}
+	if v, ok := tags["subs-only"]; ok {
+		state.SubsOnly = v == "1"
+	}
+}
+
+// describeRoomState returns a human readable line of the active chat restrictions or an empty string if there are none
+func describeRoomState(state room.State) string {
+	var settings []string
+	if state.SubsOnly {
+		settings = append(settings, "subscribers only")
+	}
+	if state.FollowersOnly == 0 {
+		settings = append(settings, "followers only")
+	} else if state.FollowersOnly > 0 {
+		settings = append(settings, fmt.Sprintf("followers only (%d minutes)", state.FollowersOnly))
+	}
+	if state.EmoteOnly {
+		settings = append(settings, "emote only")
+	}
+	if state.Slow > 0 {
+		settings = append(settings, fmt.Sprintf("slow mode (%ds)", state.Slow))
+	}
+	if state.R9K {
+		settings = append(settings, "unique messages only (r9k)")
+	}
+	if len(settings) == 0 {
+		return ""
+	}
+	return topicPrefix + strings.Join(settings, ", ")
+}
+
+// updateTopic replaces the bridge managed line of the room topic and keeps the rest as it is
+func updateTopic(roomID, line string) error {
+	current := topicContent{}
+	// A room without topic returns an error which we can ignore
+	_ = util.BotUser.MXClient.StateEvent(roomID, "m.room.topic", "", &current)
+
+	var lines []string
+	for _, v := range strings.Split(current.Topic, "\n") {
+		if v != "" && !strings.HasPrefix(v, topicPrefix) {
+			lines = append(lines, v)
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	topic := strings.Join(lines, "\n")
+	if topic == current.Topic {
+		return nil
+	}
+	_, err := util.BotUser.MXClient.SendStateEvent(roomID, "m.room.topic", "", topicContent{Topic: topic})
+	return err
+}
@@ -0,0 +1,98 @@
+package implementation
+
+import (
+	"fmt"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/room"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"github.com/matrix-org/gomatrix"
+	"html"
+)
+
+// subPlans maps the msg-param-sub-plan tag to a readable name
+var subPlans = map[string]string{
+	"Prime": "Prime",
+	"1000":  "Tier 1",
+	"2000":  "Tier 2",
+	"3000":  "Tier 3",
+}
+
+// handleUserNotice relays subs, raids and other USERNOTICE events as a notice to the portal room.
+// A message the user attached (e.g. on a resub) gets sent by the AS User afterwards.
+func (w *WebsocketHolder) handleUserNotice(msg *util.TMessage) {
+	roomID := room.GetRoomID(w.TwitchRooms, msg.Channel)
+	if roomID == "" {
+		return
+	}
+
+	formatted := renderUserNotice(msg.Tags)
+	if formatted != "" {
+		_, err := util.BotUser.MXClient.SendMessageEvent(roomID, "m.room.message", gomatrix.GetHTMLMessage("m.notice", formatted))
+		if err != nil {
+			util.AppService.Log.Errorln(err)
+			return
+		}
+	}
+
+	if msg.Message == "" || msg.Tags["login"] == "" {
+		return
+	}
+	asUser, err := w.getASUser(msg.Tags["login"], msg.Tags["display-name"])
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+		return
+	}
+	if asUser == nil {
+		return
+	}
+	updateDisplayName(asUser, msg.Tags)
+	err = joinRoom(asUser, roomID)
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+		return
+	}
+	resp, err := asUser.MXClient.SendText(roomID, msg.Message)
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+		return
+	}
+	saveMessage(msg, roomID, asUser.Mxid, resp.EventID)
+}
+
+// renderUserNotice returns the HTML shown in Matrix for a USERNOTICE based on its msg-id
+func renderUserNotice(tags map[string]string) string {
+	displayName := tags["display-name"]
+	if displayName == "" {
+		displayName = tags["login"]
+	}
+	name := "<b>" + html.EscapeString(displayName) + "</b>"
+	param := func(key string) string {
+		return html.EscapeString(tags["msg-param-"+key])
+	}
+	plan := subPlans[tags["msg-param-sub-plan"]]
+	if plan == "" {
+		plan = "a subscription"
+	}
+
+	switch tags["msg-id"] {
+	case "sub":
+		return fmt.Sprintf("%s subscribed with %s.", name, plan)
+	case "resub":
+		return fmt.Sprintf("%s resubscribed with %s. They've subscribed for %s months!", name, plan, param("cumulative-months"))
+	case "subgift":
+		return fmt.Sprintf("%s gifted a %s sub to <b>%s</b>!", name, plan, param("recipient-display-name"))
+	case "submysterygift":
+		return fmt.Sprintf("%s is gifting %s %s subs to the community!", name, param("mass-gift-count"), plan)
+	case "giftpaidupgrade", "anongiftpaidupgrade", "primepaidupgrade":
+		return fmt.Sprintf("%s is continuing their subscription.", name)
+	case "raid":
+		return fmt.Sprintf("<b>%s</b> is raiding with a party of %s!", param("displayName"), param("viewerCount"))
+	case "unraid":
+		return "The raid was cancelled."
+	case "announcement":
+		return fmt.Sprintf("<b>Announcement</b> by %s", name)
+	case "bitsbadgetier":
+		return fmt.Sprintf("%s just earned a new %s Bits badge!", name, param("threshold"))
+	default:
+		return html.EscapeString(tags["system-msg"])
+	}
+}
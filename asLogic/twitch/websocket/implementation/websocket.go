@@ -2,17 +2,18 @@
 package implementation
 
 import (
-	"fmt"
+	"context"
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/matrix_helper"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/room"
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/api"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/irc"
+	twitchWS "github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/websocket"
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/user"
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
 	"github.com/gorilla/websocket"
 	"github.com/matrix-org/gomatrix"
-	"net"
-	"os"
-	"os/signal"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,277 +21,390 @@ type WebsocketHolder struct {
 	// Websocket
 	WS *websocket.Conn
 	// Done is used to gracefully exit all WS connections
-	Done  chan struct{}
-	TRoom string
+	Done chan struct{}
+	// Owner is the MXID of the Real User this connection belongs to. It is empty for connections of the Bot
+	Owner string
+	// Username is the Twitch login the connection logs in with
+	Username string
+	// Token returns the OAuth token to login with. It gets called on every (re)connect so it can refresh the token
+	Token func() (string, error)
+	// OnStateChange gets called whenever the connection changes its state. It may be nil
+	OnStateChange func(state twitchWS.State)
 
 	Users       map[string]*user.ASUser
 	RealUsers   map[string]*user.RealUser
 	TwitchUsers map[string]*user.ASUser
 	TwitchRooms map[string]string
+	Aliases     map[string]*room.Room
+
+	// pending holds the sent messages per channel which wait for their Twitch id
+	pending    map[string][]*pendingMessage
+	pendingMux sync.Mutex
+	// names collects the NAMES reply per room until it ends
+	names map[string]map[string]bool
+
+	// wsMux makes sure only one line gets written at a time
+	wsMux    sync.Mutex
+	state    twitchWS.State
+	stateMux sync.Mutex
+	// channels holds the joined channels which get joined again after a reconnect
+	channels    map[string]bool
+	channelsMux sync.Mutex
+	// queue holds the messages waiting for the rate limit
+	queue sendQueue
+	// joins tracks which channels are joined
+	joins joinState
+	// joinLimiter holds the JOIN rate limit of the account. It gets created if the connection doesn't share one
+	joinLimiter *joinLimiter
+	// closeOnce makes sure Done only gets closed once
+	closeOnce sync.Once
+	// stopped gets closed once the supervisor exited
+	stopped chan struct{}
+	// ctx is the context the connection got connected with
+	ctx context.Context
 }
 
+// Send queues a message to the channel. It gets sent as soon as the rate limit allows it
 func (w *WebsocketHolder) Send(channel, messageRaw string) error {
-	// Send Message
-	message := "PRIVMSG #" + channel + " :" + messageRaw + "\r\n"
-	deadline := time.Now().Add(time.Second * 5)
-	err := w.WS.SetWriteDeadline(deadline)
-	if err != nil {
-		return err
-	}
-	err = w.WS.WriteMessage(websocket.TextMessage, []byte(message))
-	return err
+	return w.SendMessage(&twitchWS.OutgoingMessage{Channel: channel, Text: messageRaw})
+}
+
+// sendPrivmsg sends a message with the given IRCv3 tags attached
+func (w *WebsocketHolder) sendPrivmsg(tags map[string]string, channel, messageRaw string) error {
+	// Send Message. Line breaks would end the PRIVMSG early so they get removed
+	return w.writeLine(irc.FormatTags(tags) + "PRIVMSG #" + channel + " :" + irc.Sanitize(messageRaw))
 }
 
 func (w *WebsocketHolder) Pong(server string) error {
 	// Send Pong
-	message := "PONG :" + server + "\r\n"
-	deadline := time.Now().Add(time.Second * 5)
-	err := w.WS.SetWriteDeadline(deadline)
-	if err != nil {
-		return err
-	}
-	err = w.WS.WriteMessage(websocket.TextMessage, []byte(message))
-	return err
+	return w.writeLine("PONG :" + server)
 }
 
+// Join schedules joining the channel. It gets joined as soon as the JOIN rate limit allows it and after every reconnect
 func (w *WebsocketHolder) Join(channel string) error {
-	// Join Room
-	w.WS.SetWriteDeadline(time.Now().Add(time.Minute * 2))
-	join := "JOIN #" + channel + "\r\n"
-	util.AppService.Log.Debugln("Join Command: ", join)
-	joinByte := []byte(join)
-	util.AppService.Log.Debugln("Join Command Bytes: ", join)
-	err := w.WS.WriteMessage(websocket.TextMessage, joinByte)
-	return err
+	w.channelsMux.Lock()
+	if w.channels == nil {
+		w.channels = make(map[string]bool)
+	}
+	w.channels[channel] = true
+	w.channelsMux.Unlock()
+
+	util.AppService.Log.Debugln("Scheduling Join of: ", channel)
+	w.scheduleJoins()
+	return nil
 }
 
-// Connect opens a Websocket and requests the needed Capabilities and does the Login
-func (w *WebsocketHolder) Connect(oauthToken, username string) (err error) {
-	// Make sure to catch the Interrupt Signal to close the WS gracefully
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
+// Part leaves the channel and stops joining it after a reconnect
+func (w *WebsocketHolder) Part(channel string) error {
+	w.channelsMux.Lock()
+	delete(w.channels, channel)
+	w.channelsMux.Unlock()
+	w.forgetJoin(channel)
 
-	dialer := &websocket.Dialer{
-		NetDial: func(network, addr string) (net.Conn, error) {
-			netDialer := &net.Dialer{
-				KeepAlive: time.Minute * 60,
-			}
-			return netDialer.Dial(network, addr)
-		},
-		HandshakeTimeout: 45 * time.Second,
+	if w.State() != twitchWS.StateConnected {
+		return nil
 	}
-	w.WS, _, err = dialer.Dial("wss://irc-ws.chat.twitch.tv:443/irc", nil)
-
-	go func() {
-		for {
-			select {
-			case <-w.Done:
-				util.AppService.Log.Warnln("Done got closed")
-				util.AppService.Log.Warnln("Closing old WS")
-				w.WS.SetWriteDeadline(time.Now().Add(time.Minute * 2))
-				grerr := w.WS.Close()
-				if grerr != nil {
-					util.AppService.Log.Errorln(grerr)
-					// TODO fix properly so this can exit
-				}
-				util.AppService.Log.Warnf("%s died\n", w.TRoom)
-				util.AppService.Log.Warnln("Reconnecting WS...")
-				util.AppService.Log.Warnln("Replacing Websocket Holder")
-				*w = WebsocketHolder{
-					Done:        make(chan struct{}),
-					TwitchRooms: w.TwitchRooms,
-					TwitchUsers: w.TwitchUsers,
-					RealUsers:   w.RealUsers,
-					Users:       w.Users,
-					TRoom:       w.TRoom,
-				}
-				util.AppService.Log.Warnln("Start WS Connection")
-				grerr = w.Connect(oauthToken, username)
-				if grerr != nil {
-					util.AppService.Log.Errorln(grerr)
-					return
-				}
-				if w.TRoom != "" {
-					util.AppService.Log.Warnln("ReJoin Room")
-					grerr = w.Join(w.TRoom)
-					if grerr != nil {
-						util.AppService.Log.Errorln(grerr)
-						return
-					}
-				}
-
-				return
-			case <-interrupt:
-				// Cleanly close the connection by sending a close message and then
-				// waiting (with timeout) for the server to close the connection.
-				err = w.WS.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-				select {
-				case <-w.Done:
-				case <-time.After(time.Second):
-				}
-				os.Exit(0)
-				return
-			}
+	return w.writeLine("PART #" + channel)
+}
+
+// Channels returns all channels the connection joins
+func (w *WebsocketHolder) Channels() []string {
+	w.channelsMux.Lock()
+	defer w.channelsMux.Unlock()
+	channels := make([]string, 0, len(w.channels))
+	for channel := range w.channels {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// channelCommands are the commands every connection in a channel receives. Only the Bot relays them to Matrix
+var channelCommands = map[string]bool{
+	"PRIVMSG":    true,
+	"USERNOTICE": true,
+	"CLEARMSG":   true,
+	"CLEARCHAT":  true,
+	"353":        true,
+	"366":        true,
+}
+
+// hasChannel checks if the connection joins the channel
+func (w *WebsocketHolder) hasChannel(channel string) bool {
+	w.channelsMux.Lock()
+	defer w.channelsMux.Unlock()
+	return w.channels[channel]
+}
+
+// handleMessage answers to the PING messages by Twitch and relays messages to Matrix
+func (w *WebsocketHolder) handleMessage(parsedMessage *util.TMessage) {
+	if w.Owner != "" && channelCommands[parsedMessage.Command] {
+		// Puppets only join channels for their USERSTATE. The Bot relays the channel
+		return
+	}
+	switch parsedMessage.Command {
+	case "001":
+		w.onWelcome()
+	case "PRIVMSG":
+		w.handlePrivmsg(parsedMessage)
+	case "USERNOTICE":
+		w.handleUserNotice(parsedMessage)
+	case "USERSTATE":
+		w.handleUserState(parsedMessage)
+	case "GLOBALUSERSTATE":
+		w.handleGlobalUserState(parsedMessage)
+	case "ROOMSTATE":
+		w.confirmJoin(parsedMessage.Channel)
+		if w.Owner == "" {
+			w.handleRoomState(parsedMessage)
+		}
+	case "NOTICE":
+		if !w.handleJoinFailure(parsedMessage) {
+			w.handleNotice(parsedMessage)
 		}
-	}()
+	case "CLEARMSG":
+		w.handleClearMsg(parsedMessage)
+	case "CLEARCHAT":
+		w.handleClearChat(parsedMessage)
+	case "WHISPER":
+		w.handleWhisper(parsedMessage)
+	case "JOIN", "PART":
+		if parsedMessage.Command == "JOIN" && strings.EqualFold(parsedMessage.Username, w.Username) {
+			w.confirmJoin(parsedMessage.Channel)
+		}
+		if w.Owner == "" {
+			w.handleMembership(parsedMessage)
+		}
+	case "353", "366":
+		w.handleNames(parsedMessage)
+	case "PING":
+		util.AppService.Log.Debugln("[TWITCH]: Respond to Ping")
+		err := w.Pong(parsedMessage.Message)
+		if err != nil {
+			util.AppService.Log.Errorln(err)
+		}
+	default:
+		util.AppService.Log.Debugf("[TWITCH]: %+v\n", parsedMessage)
+	}
+}
+
+// handlePrivmsg relays a chat message to the portal room
+func (w *WebsocketHolder) handlePrivmsg(parsedMessage *util.TMessage) {
+	if w.isRealUser(parsedMessage.Username) {
+		return
+	}
+	roomID := room.GetRoomID(w.TwitchRooms, parsedMessage.Channel)
+	if roomID == "" {
+		return
+	}
+	asUser, err := w.getASUser(parsedMessage.Username, parsedMessage.Tags["display-name"])
 	if err != nil {
+		util.AppService.Log.Errorln(err)
 		return
 	}
-
-	// Request needed IRC Capabilities https://dev.twitch.tv/docs/irc/#twitch-specific-irc-capabilities
-	sendErr := w.WS.WriteMessage(websocket.TextMessage, []byte("CAP REQ :twitch.tv/membership twitch.tv/tags\r\n"))
-	if sendErr != nil {
-		err = sendErr
+	if asUser == nil {
 		return
 	}
 
-	// Login
-	sendErr = w.WS.WriteMessage(websocket.TextMessage, []byte("PASS oauth:"+oauthToken+"\r\n"))
-	if sendErr != nil {
-		err = sendErr
+	updateDisplayName(asUser, parsedMessage.Tags)
+	powerLevels.set(roomID, asUser.Mxid, levelForBadges(parsedMessage.Tags["badges"]))
+	err = joinRoom(asUser, roomID)
+	if err != nil {
+		util.AppService.Log.Errorln(err)
 		return
 	}
-	sendErr = w.WS.WriteMessage(websocket.TextMessage, []byte("NICK "+username+"\r\n"))
-	if sendErr != nil {
-		err = sendErr
+
+	msgType := "m.text"
+	text, isAction := irc.ParseAction(parsedMessage.Message)
+	if isAction {
+		msgType = "m.emote"
+	}
+	content := &matrix_helper.MessageContent{MsgType: msgType, Body: text}
+	content.SetMentions(w.mentionedMXIDs(text))
+	if formatted := w.renderMessage(text, parsedMessage.Tags, isAction); formatted != "" {
+		content.Format = "org.matrix.custom.html"
+		content.FormattedBody = formatted
+	}
+	if parentID := parsedMessage.Tags["reply-parent-msg-id"]; parentID != "" {
+		parent, err := util.DB.GetMessageByTwitchID(parentID)
+		if err != nil {
+			util.AppService.Log.Errorln(err)
+		} else if parent != nil && parent.RoomID == roomID {
+			content.SetReply(parent.EventID)
+		}
+	}
+	resp, err := asUser.MXClient.SendMessageEvent(roomID, "m.room.message", content)
+	if err != nil {
+		util.AppService.Log.Errorln(err)
 		return
 	}
+	saveMessage(parsedMessage, roomID, asUser.Mxid, resp.EventID)
+}
+
+// isRealUser checks if the Twitch user is a logged in Matrix user
+func (w *WebsocketHolder) isRealUser(username string) bool {
+	for _, v := range user.ListRealUsers(w.RealUsers) {
+		if username == v.TwitchName {
+			return true
+		}
+	}
+	return false
+}
 
-	w.WS.SetCloseHandler(func(code int, text string) error {
-		err := w.WS.Close()
+// getASUser returns the AS User of a Twitch user and creates it if needed.
+// The login comes from Twitch so the user exists. displayName is the display-name tag and may be empty.
+// It returns nil if the login is empty.
+func (w *WebsocketHolder) getASUser(username, displayName string) (*user.ASUser, error) {
+	if username == "" {
+		return nil, nil
+	}
+	asUser := user.GetASUser(w.TwitchUsers, username)
+	if asUser != nil {
+		return asUser, nil
+	}
+
+	for _, v := range util.AppService.Registration.Namespaces.UserIDs {
+		// name magic
+		pre := strings.Split(v.Regex, ".+")[0]
+		suff := strings.Split(v.Regex, ".+")[1]
+		asUser = &user.ASUser{}
+		asUser.Mxid = pre + username + suff
+		util.AppService.Log.Debugln(asUser.Mxid)
+		MXusername := strings.Split(strings.TrimPrefix(asUser.Mxid, "@"), ":")[0]
+		util.AppService.Log.Debugln(MXusername)
+		client, err := gomatrix.NewClient(util.AppService.HomeserverURL, asUser.Mxid, util.AppService.Registration.AppToken)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		err = w.Connect(oauthToken, username)
-		return err
-	})
+		asUser.MXClient = client
+		asUser.TwitchName = username
 
-	return
-}
+		err = matrix_helper.CreateUser(client, MXusername)
+		if err != nil {
+			return nil, err
+		}
+
+		client.AppServiceUserID = asUser.Mxid
 
-// Listen answers to the PING messages by Twitch and relays messages to Matrix
-func (w *WebsocketHolder) Listen() {
-	go func() {
-		defer close(w.Done)
-		for {
-			_, message, err := w.WS.ReadMessage()
+		// The profile lookup only adds the avatar. The ghost gets created without it if the lookup fails
+		var logo string
+		userdata, err := api.RequestUserData(username)
+		if err != nil {
+			util.AppService.Log.Debugln("Twitch profile of", username, "unavailable:", err)
+		} else if userdata.Total > 0 {
+			if displayName == "" {
+				displayName = userdata.Users[0].DisplayName
+			}
+			logo = userdata.Users[0].Logo
+		}
+		if displayName == "" {
+			displayName = username
+		}
+		err = client.SetDisplayName(displayName + " (Twitch)")
+		if err != nil {
+			util.AppService.Log.Errorln(err)
+		} else {
+			asUser.DisplayName = displayName
+		}
+		var resp *gomatrix.RespMediaUpload
+		if logo != "" {
+			resp, err = client.UploadLink(logo)
 			if err != nil {
 				util.AppService.Log.Errorln(err)
-				return
 			}
+		}
+		if resp != nil && resp.ContentURI != "" {
+			err = client.SetAvatarURL(resp.ContentURI)
+			if err != nil {
+				util.AppService.Log.Errorln(err)
+			}
+		}
 
-			util.AppService.Log.Debugf("recv: %s\n", message)
-			parsedMessage := parseMessage(fmt.Sprintf("%s", message))
-			if parsedMessage != nil {
-				switch parsedMessage.Command {
-				case "PRIVMSG":
-					real := false
-					for _, v := range w.RealUsers {
-						if parsedMessage.Username == v.TwitchName {
-							real = true
-							break
-						}
-					}
-					if real {
-						continue
-					}
-					room := w.TwitchRooms[strings.TrimPrefix(parsedMessage.Channel, "#")]
-					asUser := w.TwitchUsers[parsedMessage.Username]
-
-					//Create AS User if needed and invite to room
-					if asUser == nil {
-						check, err := api.CheckTwitchUser(parsedMessage.Username)
-						if err != nil {
-							util.AppService.Log.Errorln(err)
-							return
-						}
-						if !check {
-							return
-						}
-
-						for _, v := range util.AppService.Registration.Namespaces.UserIDs {
-							// name magic
-							pre := strings.Split(v.Regex, ".+")[0]
-							suff := strings.Split(v.Regex, ".+")[1]
-							asUser = &user.ASUser{}
-							asUser.Mxid = pre + parsedMessage.Username + suff
-							util.AppService.Log.Debugln(asUser.Mxid)
-							MXusername := strings.Split(strings.TrimPrefix(asUser.Mxid, "@"), ":")[0]
-							util.AppService.Log.Debugln(MXusername)
-							client, err := gomatrix.NewClient(util.AppService.HomeserverURL, asUser.Mxid, util.AppService.Registration.AppToken)
-							if err != nil {
-								util.AppService.Log.Errorln(err)
-								return
-							}
-							asUser.MXClient = client
-							asUser.TwitchName = parsedMessage.Username
-
-							err = matrix_helper.CreateUser(client, MXusername)
-							if err != nil {
-								util.AppService.Log.Errorln(err)
-								return
-							}
-
-							client.AppServiceUserID = asUser.Mxid
-
-							userdata, err := api.RequestUserData(parsedMessage.Username)
-							if err != nil {
-								util.AppService.Log.Errorln(err)
-								return
-							}
-							if userdata.Total == 0 {
-								util.AppService.Log.Errorln("user missing")
-								return
-							}
-							err = client.SetDisplayName(userdata.Users[0].DisplayName + " (Twitch)")
-							if err != nil {
-								util.AppService.Log.Errorln(err)
-							}
-							var resp *gomatrix.RespMediaUpload
-							if userdata.Users[0].Logo != "" {
-								resp, err = client.UploadLink(userdata.Users[0].Logo)
-								if err != nil {
-									util.AppService.Log.Errorln(err)
-								}
-							}
-							if resp != nil && resp.ContentURI != "" {
-								err = client.SetAvatarURL(resp.ContentURI)
-								if err != nil {
-									util.AppService.Log.Errorln(err)
-								}
-							}
-
-							w.TwitchUsers[parsedMessage.Username] = asUser
-							w.Users[asUser.Mxid] = asUser
-							err = util.DB.SaveUser(w.TwitchUsers[parsedMessage.Username])
-							if err != nil {
-								util.AppService.Log.Errorln(err)
-							}
-							break
-						}
-					}
-
-					// Check if user needs to join the room
-					joinedResp, err := util.BotUser.MXClient.JoinedMembers(room)
-					if err != nil {
-						util.AppService.Log.Errorln(err)
-						return
-					}
-					mxid := asUser.Mxid
-					if _, ok := joinedResp.Joined[mxid]; !ok {
-						asUser.MXClient.JoinRoom(room, "", nil)
-					}
-
-					asUser.MXClient.SendText(room, parsedMessage.Message)
-				case "PING":
-					util.AppService.Log.Debugln("[TWITCH]: Respond to Ping")
-					w.Pong(parsedMessage.Message)
-				default:
-					util.AppService.Log.Debugf("[TWITCH]: %+v\n", parsedMessage)
-				}
+		var added bool
+		asUser, added = user.AddASUser(w.Users, w.TwitchUsers, asUser)
+		if added {
+			err = util.DB.SaveUser(asUser)
+			if err != nil {
+				util.AppService.Log.Errorln(err)
 			}
 		}
-	}()
+		break
+	}
+
+	return asUser, nil
+}
+
+// mxidForLogin returns the MXID representing the Twitch user in Matrix or an empty string if the user is unknown
+func (w *WebsocketHolder) mxidForLogin(login string) string {
+	if asUser := user.GetASUser(w.TwitchUsers, login); asUser != nil {
+		return asUser.Mxid
+	}
+	for _, v := range user.ListRealUsers(w.RealUsers) {
+		if v.TwitchName == login {
+			return v.Mxid
+		}
+	}
+	return ""
+}
+
+// roomByChannel returns the portal room of a Twitch channel or nil if the channel isn't bridged
+func (w *WebsocketHolder) roomByChannel(channel string) *room.Room {
+	for _, v := range room.ListRooms(w.Aliases) {
+		if v.TwitchChannel == channel {
+			return v
+		}
+	}
+	return nil
+}
+
+// updateDisplayName sets the display-name tag of a message as display name of the AS User if it changed
+func updateDisplayName(asUser *user.ASUser, tags map[string]string) {
+	displayName := tags["display-name"]
+	if displayName == "" || displayName == asUser.DisplayName {
+		return
+	}
+	if asUser.DisplayName == "" {
+		// The name isn't stored in the DB so after a restart the profile tells what is set
+		resp, err := asUser.MXClient.GetDisplayName(asUser.Mxid)
+		if err == nil && resp.DisplayName == displayName+" (Twitch)" {
+			asUser.DisplayName = displayName
+			return
+		}
+	}
+	err := asUser.MXClient.SetDisplayName(displayName + " (Twitch)")
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+		return
+	}
+	asUser.DisplayName = displayName
+}
+
+// joinRoom lets the AS User join the room if it isn't joined yet
+func joinRoom(asUser *user.ASUser, room string) error {
+	joinedResp, err := util.BotUser.MXClient.JoinedMembers(room)
+	if err != nil {
+		return err
+	}
+	if _, ok := joinedResp.Joined[asUser.Mxid]; !ok {
+		_, err = asUser.MXClient.JoinRoom(room, "", nil)
+	}
+	return err
+}
+
+// saveMessage remembers which Matrix event a Twitch message got bridged as
+func saveMessage(msg *util.TMessage, roomID, sender, eventID string) {
+	if msg.Tags["id"] == "" || eventID == "" {
+		return
+	}
+	err := util.DB.SaveMessage(&room.Message{
+		TwitchID:  msg.Tags["id"],
+		EventID:   eventID,
+		RoomID:    roomID,
+		Sender:    sender,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+	}
 }
 
 func parseMessage(message string) (parsedMessage *util.TMessage) {
@@ -299,20 +413,23 @@ func parseMessage(message string) (parsedMessage *util.TMessage) {
 		@badges=broadcaster/1;color=#D2691E;display-name=MTRNord;emotes=;id=3e969619-5312-4999-ba21-6d0ab81af8f5;mod=0;room-id=36031510;subscriber=0;tmi-sent-ts=1523458219318;turbo=0;user-id=36031510;user-type= :mtrnord!mtrnord@mtrnord.tmi.twitch.tv PRIVMSG #mtrnord :test
 	*/
 
-	parsedMessage = &util.TMessage{}
-	if strings.HasPrefix(message, "@") {
-		messageSplit := strings.Split(message, " ")
-		parsedMessage.Tags = strings.TrimSpace(messageSplit[0])
-		parsedMessage.Username = strings.Split(strings.TrimLeft(strings.TrimSpace(messageSplit[1]), ":"), "!")[0]
-		parsedMessage.Command = strings.TrimSpace(messageSplit[2])
-		parsedMessage.Channel = strings.TrimSpace(messageSplit[3])
-		rawMessageText := strings.TrimPrefix(message, messageSplit[0]+" "+messageSplit[1]+" "+messageSplit[2]+" "+messageSplit[3]+" ")
-		parsedMessage.Message = strings.TrimLeft(strings.TrimSpace(rawMessageText), ":")
-	} else if strings.HasPrefix(message, "PING") {
-		parsedMessage.Command = "PING"
-		parsedMessage.Message = strings.Split(message, ":")[1]
-	} else {
-		parsedMessage = nil
+	ircMessage, err := irc.Parse(message)
+	if err != nil {
+		util.AppService.Log.Debugln(err)
+		return nil
+	}
+
+	parsedMessage = &util.TMessage{
+		Tags:     ircMessage.Tags,
+		Command:  ircMessage.Command,
+		Params:   ircMessage.Params,
+		Original: ircMessage.Raw,
+		Channel:  ircMessage.Channel(),
+		Username: ircMessage.Prefix.Nick,
+	}
+	// Lines like "ROOMSTATE #channel" only carry the channel and no message text
+	if len(ircMessage.Params) > 1 || parsedMessage.Channel == "" {
+		parsedMessage.Message = ircMessage.Trailing()
 	}
 
 	return
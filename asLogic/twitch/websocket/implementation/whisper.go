@@ -0,0 +1,70 @@
+package implementation
+
+import (
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/matrix_helper"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/room"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/user"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"github.com/matrix-org/gomatrix"
+)
+
+// handleWhisper relays a whisper the Real User received into their DM with the ghost of the sender
+func (w *WebsocketHolder) handleWhisper(msg *util.TMessage) {
+	// Only the connections of Real Users receive whispers
+	if w.Owner == "" || msg.Username == "" {
+		return
+	}
+
+	asUser, err := w.getASUser(msg.Username, msg.Tags["display-name"])
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+		return
+	}
+	if asUser == nil {
+		return
+	}
+	updateDisplayName(asUser, msg.Tags)
+
+	roomID, err := w.whisperRoom(asUser)
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+		return
+	}
+
+	content := &matrix_helper.MessageContent{MsgType: "m.text", Body: msg.Message}
+	content.SetMentions(w.mentionedMXIDs(msg.Message))
+	if formatted := w.renderMessage(msg.Message, msg.Tags, false); formatted != "" {
+		content.Format = "org.matrix.custom.html"
+		content.FormattedBody = formatted
+	}
+	_, err = asUser.MXClient.SendMessageEvent(roomID, "m.room.message", content)
+	if err != nil {
+		util.AppService.Log.Errorln(err)
+	}
+}
+
+// whisperRoom returns the DM between the Real User and the ghost and creates it if needed
+func (w *WebsocketHolder) whisperRoom(asUser *user.ASUser) (string, error) {
+	whisperRoom, err := util.DB.GetWhisperRoom(w.Owner, asUser.TwitchName)
+	if err != nil {
+		return "", err
+	}
+	if whisperRoom != nil {
+		return whisperRoom.ID, nil
+	}
+
+	resp, err := asUser.MXClient.CreateRoom(&gomatrix.ReqCreateRoom{
+		Preset:   "trusted_private_chat",
+		IsDirect: true,
+		Invite:   []string{w.Owner},
+	})
+	if err != nil {
+		return "", err
+	}
+	err = util.DB.SaveWhisperRoom(&room.WhisperRoom{
+		ID:         resp.RoomID,
+		Mxid:       w.Owner,
+		TwitchName: asUser.TwitchName,
+	})
+	return resp.RoomID, err
+}
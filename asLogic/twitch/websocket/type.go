@@ -1,11 +1,48 @@
 package websocket
 
-import "github.com/gorilla/websocket"
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+)
+
+// ErrQueueFull is returned by SendMessage if too many messages wait for the rate limit of Twitch
+var ErrQueueFull = errors.New("too many messages are waiting to be sent to Twitch")
 
 type WebsocketHolder interface {
 	Send(channel, messageRaw string) error
+	SendMessage(messages ...*OutgoingMessage) error
 	Join(channel string) error
-	Connect(oauthToken, username string) (err error)
+	Connect(ctx context.Context) error
+	Close(ctx context.Context) error
 	GetWS() *websocket.Conn
-	Listen()
+	State() State
+}
+
+// State is the state of a Twitch connection
+type State string
+
+const (
+	// StateConnecting is set while the connection gets opened and the login isn't confirmed yet.
+	// It is also set while waiting to redial after an open connection dropped
+	StateConnecting State = "connecting"
+	// StateConnected is set once Twitch confirmed the login
+	StateConnected State = "connected"
+	// StateReconnecting is set while waiting for the next try after opening the connection failed
+	StateReconnecting State = "reconnecting"
+	// StateClosed is set after the connection got closed for good
+	StateClosed State = "closed"
+)
+
+// OutgoingMessage is a message sent to Twitch on behalf of a Matrix event
+type OutgoingMessage struct {
+	Channel string
+	Text    string
+	// Tags are sent as IRCv3 client tags like "reply-parent-msg-id"
+	Tags map[string]string
+
+	// RoomID, EventID and Sender identify the Matrix event the message originates from
+	RoomID  string
+	EventID string
+	Sender  string
 }
@@ -0,0 +1,26 @@
+package user
+
+import "sync"
+
+// ghostsMux guards the maps of AS Users which the queryHandler and all Twitch connections share
+var ghostsMux sync.RWMutex
+
+// GetASUser returns the AS User stored under the key (MXID or Twitch login depending on the map) or nil
+func GetASUser(users map[string]*ASUser, key string) *ASUser {
+	ghostsMux.RLock()
+	defer ghostsMux.RUnlock()
+	return users[key]
+}
+
+// AddASUser stores the AS User by MXID and by Twitch login.
+// If the Twitch user got added in the meantime the existing AS User is returned and added is false.
+func AddASUser(users, twitchUsers map[string]*ASUser, asUser *ASUser) (stored *ASUser, added bool) {
+	ghostsMux.Lock()
+	defer ghostsMux.Unlock()
+	if existing := twitchUsers[asUser.TwitchName]; existing != nil {
+		return existing, false
+	}
+	users[asUser.Mxid] = asUser
+	twitchUsers[asUser.TwitchName] = asUser
+	return asUser, true
+}
@@ -0,0 +1,36 @@
+package user
+
+import "sync"
+
+// realUsersMux guards the map of Real Users which the queryHandler and all Twitch connections share
+var realUsersMux sync.RWMutex
+
+// GetRealUser returns the Real User stored under the MXID or nil
+func GetRealUser(users map[string]*RealUser, mxid string) *RealUser {
+	realUsersMux.RLock()
+	defer realUsersMux.RUnlock()
+	return users[mxid]
+}
+
+// AddRealUser stores the Real User by MXID.
+// If a Real User with that MXID got added in the meantime it is returned and added is false.
+func AddRealUser(users map[string]*RealUser, ruser *RealUser) (stored *RealUser, added bool) {
+	realUsersMux.Lock()
+	defer realUsersMux.Unlock()
+	if existing := users[ruser.Mxid]; existing != nil {
+		return existing, false
+	}
+	users[ruser.Mxid] = ruser
+	return ruser, true
+}
+
+// ListRealUsers returns a snapshot of the Real Users which can be ranged over without holding the lock
+func ListRealUsers(users map[string]*RealUser) []*RealUser {
+	realUsersMux.RLock()
+	defer realUsersMux.RUnlock()
+	realUsers := make([]*RealUser, 0, len(users))
+	for _, v := range users {
+		realUsers = append(realUsers, v)
+	}
+	return realUsers
+}
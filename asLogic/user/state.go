@@ -0,0 +1,98 @@
+package user
+
+import (
+	"strings"
+	"sync"
+)
+
+// TwitchState contains what Twitch tells about an account with GLOBALUSERSTATE and USERSTATE
+type TwitchState struct {
+	mux sync.RWMutex
+	// UserID is the Twitch user id of the account
+	UserID string
+	Color  string
+	// Badges holds the global badges by name with their version
+	Badges    map[string]string
+	EmoteSets []string
+	// channels holds the state per channel name
+	channels map[string]*ChannelState
+}
+
+// ChannelState contains the USERSTATE of an account in a single channel
+type ChannelState struct {
+	Badges    map[string]string
+	EmoteSets []string
+	Moderator bool
+}
+
+// SetGlobal updates the state with the tags of a GLOBALUSERSTATE
+func (s *TwitchState) SetGlobal(tags map[string]string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if tags["user-id"] != "" {
+		s.UserID = tags["user-id"]
+	}
+	s.Color = tags["color"]
+	s.Badges = ParseBadges(tags["badges"])
+	s.EmoteSets = parseEmoteSets(tags["emote-sets"])
+}
+
+// SetChannel updates the state of the channel with the tags of a USERSTATE
+func (s *TwitchState) SetChannel(channel string, tags map[string]string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.channels == nil {
+		s.channels = make(map[string]*ChannelState)
+	}
+	badges := ParseBadges(tags["badges"])
+	_, broadcaster := badges["broadcaster"]
+	_, moderator := badges["moderator"]
+	s.channels[channel] = &ChannelState{
+		Badges:    badges,
+		EmoteSets: parseEmoteSets(tags["emote-sets"]),
+		Moderator: broadcaster || moderator || tags["mod"] == "1",
+	}
+	if tags["color"] != "" {
+		s.Color = tags["color"]
+	}
+}
+
+// Channel returns the state in the channel or nil if Twitch didn't send a USERSTATE for it yet
+func (s *TwitchState) Channel(channel string) *ChannelState {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.channels[channel]
+}
+
+// IsModerator checks if the account is a moderator or the broadcaster of the channel
+func (s *TwitchState) IsModerator(channel string) bool {
+	state := s.Channel(channel)
+	return state != nil && state.Moderator
+}
+
+// ParseBadges parses a badges tag like "moderator/1,subscriber/12" into the badge names with their version
+func ParseBadges(tag string) map[string]string {
+	badges := make(map[string]string)
+	for _, badge := range strings.Split(tag, ",") {
+		if badge == "" {
+			continue
+		}
+		parts := strings.SplitN(badge, "/", 2)
+		if len(parts) == 2 {
+			badges[parts[0]] = parts[1]
+		} else {
+			badges[parts[0]] = ""
+		}
+	}
+	return badges
+}
+
+func parseEmoteSets(tag string) []string {
+	var sets []string
+	for _, set := range strings.Split(tag, ",") {
+		if set != "" {
+			sets = append(sets, set)
+		}
+	}
+	return sets
+}
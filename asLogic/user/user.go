@@ -12,7 +12,9 @@ import (
 type ASUser struct {
 	Mxid       string
 	TwitchName string
-	MXClient   *gomatrix.Client
+	// DisplayName is the Twitch display name currently set on the Matrix profile
+	DisplayName string
+	MXClient    *gomatrix.Client
 }
 
 // RealUser contains the required Information for a Real User
@@ -26,6 +28,8 @@ type RealUser struct {
 	// Room holds a ID of a room with the Real User and the Bot
 	Room string
 	Mux  sync.Mutex
+	// TwitchState holds the badges, color and emote sets Twitch sent for the account
+	TwitchState TwitchState
 }
 
 // BotUser contains the required Information for a Bot User
@@ -36,4 +40,6 @@ type BotUser struct {
 	TwitchToken string
 	Mux         sync.Mutex
 	MXClient    *gomatrix.Client
+	// TwitchState holds the badges, color and emote sets Twitch sent for the account
+	TwitchState TwitchState
 }
@@ -4,6 +4,7 @@ import (
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/db"
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/user"
 	"maunium.net/go/mautrix/appservice"
+	"time"
 )
 
 // AppService makes the appservice accessible everywhere in the Golang Code
@@ -36,11 +37,46 @@ var Publicaddress string
 
 var DB db.Handler
 
+// ClearChatMode defines how a CLEARCHAT for a single user is mirrored to Matrix. Either "redact" or "notice"
+var ClearChatMode string
+
+// MessageRetention defines how long the mapping between Twitch messages and Matrix events is kept. 0 keeps it forever
+var MessageRetention time.Duration
+
+// RoomStateTopic enables a line describing the Twitch chat settings in the topic of portal rooms
+var RoomStateTopic bool
+
+// MediaExpiry defines how long links to Matrix media posted in Twitch stay valid. 0 keeps them forever
+var MediaExpiry time.Duration
+
+// NoMediaChannels holds the Twitch channels which get no media from Matrix unless their portal room has a media state event
+var NoMediaChannels []string
+
+// EditFormat defines how edits are sent to Twitch. "full" sends "* corrected text", "diff" only sends the changed words like "*fix"
+var EditFormat string
+
+// EditWindow defines how old a message may be to still send its edits to Twitch. 0 sends all edits
+var EditWindow time.Duration
+
+// MaxMessageParts defines into how many Twitch messages a long Matrix message gets split at most
+var MaxMessageParts int
+
+// ChannelsPerConnection defines how many channels the Bot joins over a single connection. 0 uses one connection for all
+var ChannelsPerConnection int
+
+// MirrorMembership enables letting ghosts join and leave portal rooms when the Twitch users join or leave the chat
+var MirrorMembership bool
+
+// ColorNames enables coloring the sender name of bridged messages with the Twitch chat color of the user
+var ColorNames bool
+
 // TMessage is a struct with information about a Message send by Twitch
 type TMessage struct {
-	Message  string
-	Tags     string
+	Message string
+	// Tags holds the unescaped IRCv3 tags of the Message
+	Tags     map[string]string
 	Command  string
+	Params   []string
 	Original string
 	Channel  string
 	Username string
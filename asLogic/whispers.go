@@ -0,0 +1,117 @@
+package asLogic
+
+import (
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/queryHandler"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/room"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/api"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/irc"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/twitch/login"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/user"
+	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
+	"maunium.net/go/mautrix/event"
+	"net/http"
+)
+
+// ghostInviteHandler lets a ghost join the DM it got invited to and remembers the room for whispers.
+// Invites into rooms which aren't DMs or are portals get ignored.
+func ghostInviteHandler(e *event.Event) error {
+	if e.StateKey == nil || *e.StateKey == util.BotUser.Mxid || !e.Content.AsMember().IsDirect {
+		return nil
+	}
+	qHandler := queryHandler.QueryHandler()
+	if user.GetASUser(qHandler.Users, e.Sender.String()) != nil || e.Sender.String() == util.BotUser.Mxid {
+		return nil
+	}
+	for _, v := range room.ListRooms(qHandler.Aliases) {
+		if v.ID == e.RoomID.String() {
+			return nil
+		}
+	}
+	if !qHandler.QueryUser(*e.StateKey) {
+		return nil
+	}
+	asUser := user.GetASUser(qHandler.Users, *e.StateKey)
+
+	util.AppService.Log.Debugln("Ghost got invited. Joining DM")
+	_, err := asUser.MXClient.JoinRoom(e.RoomID.String(), "", nil)
+	if err != nil {
+		return err
+	}
+	return util.DB.SaveWhisperRoom(&room.WhisperRoom{
+		ID:         e.RoomID.String(),
+		Mxid:       e.Sender.String(),
+		TwitchName: asUser.TwitchName,
+	})
+}
+
+// sendWhisper sends a message of the Real User in the DM as whisper to the Twitch user of the DM
+func sendWhisper(whisperRoom *room.WhisperRoom, e *event.Event) error {
+	qHandler := queryHandler.QueryHandler()
+	asUser := user.GetASUser(qHandler.TwitchUsers, whisperRoom.TwitchName)
+	if asUser == nil || e.Sender.String() != whisperRoom.Mxid {
+		return nil
+	}
+
+	mxUser := user.GetRealUser(qHandler.RealUsers, whisperRoom.Mxid)
+	if mxUser == nil {
+		mxUser, _ = user.AddRealUser(qHandler.RealUsers, &user.RealUser{Mxid: whisperRoom.Mxid})
+	}
+	if mxUser.TwitchTokenStruct == nil || mxUser.TwitchTokenStruct.AccessToken == "" {
+		_, err := asUser.MXClient.SendNotice(whisperRoom.ID, "You need to login to Twitch before you can send whispers. The bridge bot sent you a login link.")
+		if err != nil {
+			return err
+		}
+		return login.SendLoginURL(mxUser)
+	}
+
+	content := e.Content.AsMessage()
+	if isEdit(content) {
+		// Whispers can't be corrected and sending the edit as new whisper would only confuse
+		return nil
+	}
+	var message string
+	switch content.MsgType {
+	case event.MsgText:
+		message = formatForTwitch(content)
+	case event.MsgEmote:
+		message = irc.Action(formatForTwitch(content))
+	default:
+		_, err := asUser.MXClient.SendNotice(whisperRoom.ID, "Please use Text only as Twitch doesn't support any other Media Format in whispers!")
+		return err
+	}
+
+	client := login.HTTPClient(mxUser)
+	from, err := api.GetHelixUser(client, "")
+	if err != nil {
+		return err
+	}
+	to, err := api.GetHelixUser(client, whisperRoom.TwitchName)
+	if err != nil {
+		return err
+	}
+
+	parts := irc.SplitMessage(message, irc.MaxMessageLength)
+	truncated := false
+	if util.MaxMessageParts > 0 && len(parts) > util.MaxMessageParts {
+		parts = parts[:util.MaxMessageParts]
+		truncated = true
+	}
+	for _, part := range parts {
+		err = api.SendWhisper(client, from.ID, to.ID, part)
+		if err != nil {
+			break
+		}
+	}
+	if helixErr, ok := err.(*api.HelixError); ok {
+		notice := "The whisper couldn't be sent: " + helixErr.Message
+		if helixErr.Status == http.StatusUnauthorized {
+			notice = "The whisper couldn't be sent. Please login again to allow the bridge to send whispers."
+		}
+		_, err = asUser.MXClient.SendNotice(whisperRoom.ID, notice)
+		return err
+	}
+	if err == nil && truncated {
+		_, err = asUser.MXClient.SendNotice(whisperRoom.ID, truncatedNotice())
+	}
+	return err
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/Nordgedanken/matrix-twitch-bridge/asLogic/util"
 	"github.com/spf13/cobra"
 	"log"
+	"time"
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -40,7 +41,9 @@ var rootCmd = &cobra.Command{
 			asLogic.Init()
 		} else {
 			err = asLogic.Run()
-			log.Panicln(err)
+			if err != nil {
+				log.Panicln(err)
+			}
 		}
 	},
 }
@@ -62,7 +65,18 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&util.ClientSecret, "client_secret", "", "client_secret of the registered Twitch App")
 	rootCmd.PersistentFlags().StringVar(&util.BotAToken, "bot_accessToken", "", "accessToken of the Twitch Bot User. You can acquire this by opening https://twitchapps.com/tmi and removing \"oauth:\" at the front")
 	rootCmd.PersistentFlags().StringVar(&util.BotUName, "bot_username", "", "username of the Twitch Bot User.")
-	rootCmd.PersistentFlags().StringVar(&util.Publicaddress, "public_address", "", "Address of the Public Listening HTTP Server (used for the Twitch Callback)")
+	rootCmd.PersistentFlags().StringVar(&util.Publicaddress, "public_address", "", "Address of the Public Listening HTTP Server (used for the Twitch Callback and links to Matrix media)")
 	rootCmd.PersistentFlags().StringVar(&util.TLSCert, "tls_cert", "", "Path to TLS Cert File.")
 	rootCmd.PersistentFlags().StringVar(&util.TLSKey, "tls_key", "", "Path to TLS Key File.")
+	rootCmd.PersistentFlags().DurationVar(&util.MessageRetention, "message_retention", 30*24*time.Hour, "How long the mapping between Twitch messages and Matrix events is kept (0 keeps it forever)")
+	rootCmd.PersistentFlags().BoolVar(&util.RoomStateTopic, "roomstate_topic", false, "Show the Twitch chat settings like slow mode or sub-only in the topic of portal rooms")
+	rootCmd.PersistentFlags().DurationVar(&util.MediaExpiry, "media_expiry", 0, "How long links to Matrix media posted in Twitch stay valid (0 keeps them forever)")
+	rootCmd.PersistentFlags().StringSliceVar(&util.NoMediaChannels, "no_media_channels", nil, "Twitch channels which don't allow links. Matrix media doesn't get bridged to them unless the de.nordgedanken.twitch.media state event of the portal room allows it")
+	rootCmd.PersistentFlags().StringVar(&util.EditFormat, "edit_format", "full", "How Matrix edits are sent to Twitch. \"full\" sends \"* corrected text\", \"diff\" only sends the changed words like \"*fix\"")
+	rootCmd.PersistentFlags().DurationVar(&util.EditWindow, "edit_window", 10*time.Minute, "How old a message may be to still send its edits to Twitch (0 sends all edits)")
+	rootCmd.PersistentFlags().IntVar(&util.MaxMessageParts, "max_message_parts", 5, "Into how many Twitch messages a long or multi-line Matrix message gets split at most. The rest gets dropped")
+	rootCmd.PersistentFlags().IntVar(&util.ChannelsPerConnection, "channels_per_connection", 50, "How many channels the Bot joins over a single Twitch connection (0 uses one connection for all)")
+	rootCmd.PersistentFlags().BoolVar(&util.MirrorMembership, "mirror_membership", false, "Let ghosts join and leave portal rooms when the Twitch users join or leave the chat. This creates a ghost for everyone in chat")
+	rootCmd.PersistentFlags().BoolVar(&util.ColorNames, "color_names", false, "Color the sender name of messages from Twitch with the chat color the user picked on Twitch")
+	rootCmd.PersistentFlags().StringVar(&util.ClearChatMode, "clearchat_mode", "redact", "What to do in Matrix when a Twitch user gets timed out or banned. \"redact\" removes their recent messages, \"notice\" only posts a notice")
 }